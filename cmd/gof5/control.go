@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/control"
+)
+
+// controlCommand sends the command to the running gof5 process through the
+// control socket
+func controlCommand(command string, args []string) error {
+	var jsonOutput bool
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.BoolVar(&jsonOutput, "json", false, "Print the raw JSON response")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gof5 %s [--json] [connection name]\n", command)
+		fmt.Fprintf(fs.Output(), "Applies to all connections, when the name is not specified\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	resp, err := control.Send(filepath.Join(dir, control.SocketName), control.Request{
+		Command: command,
+		Name:    fs.Arg(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+
+	for _, v := range resp.Connections {
+		printStatus(os.Stdout, v)
+	}
+	if command == control.CommandStatus {
+		printDNSStats(os.Stdout, resp)
+	}
+
+	return nil
+}
+
+// dnsLogCommand toggles the DNS proxy query log through the control socket
+func dnsLogCommand(args []string) error {
+	fs := flag.NewFlagSet(control.CommandDNSLog, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gof5 %s on|off\n", control.CommandDNSLog)
+	}
+	fs.Parse(args)
+
+	var enable bool
+	switch fs.Arg(0) {
+	case "on":
+		enable = true
+	case "off":
+	default:
+		fs.Usage()
+		return fmt.Errorf("invalid %q DNS query log state", fs.Arg(0))
+	}
+
+	dir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	resp, err := control.Send(filepath.Join(dir, control.SocketName), control.Request{
+		Command: control.CommandDNSLog,
+		Enable:  enable,
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.DNSQueryLog {
+		fmt.Println("DNS query log is enabled")
+	} else {
+		fmt.Println("DNS query log is disabled")
+	}
+
+	return nil
+}
+
+func printStatus(w io.Writer, s control.Status) {
+	name := s.Server
+	if s.Name != "" {
+		name = fmt.Sprintf("%s (%s)", s.Name, s.Server)
+	}
+	fmt.Fprintf(w, "%s: %s\n", name, s.State)
+
+	if s.Error != "" {
+		fmt.Fprintf(w, "  Error:        %s\n", s.Error)
+	}
+
+	if s.Interface != "" {
+		fmt.Fprintf(w, "  Interface:    %s\n", s.Interface)
+	}
+	if s.LocalIPv4 != nil {
+		fmt.Fprintf(w, "  IPv4:         %s, gateway %s\n", s.LocalIPv4, s.ServerIPv4)
+	}
+	if s.LocalIPv6 != nil {
+		fmt.Fprintf(w, "  IPv6:         %s, gateway %s\n", s.LocalIPv6, s.ServerIPv6)
+	}
+	if len(s.Routes) > 0 {
+		fmt.Fprintf(w, "  Routes:       %s\n", strings.Join(s.Routes, ", "))
+	}
+	if len(s.DNS) > 0 {
+		fmt.Fprintf(w, "  DNS:          %s\n", s.DNS)
+	}
+	if len(s.DNSSuffixes) > 0 {
+		fmt.Fprintf(w, "  DNS suffixes: %s\n", strings.Join(s.DNSSuffixes, ", "))
+	}
+	if len(s.DNSZones) > 0 {
+		fmt.Fprintf(w, "  DNS zones:    %s\n", strings.Join(s.DNSZones, ", "))
+	}
+
+	if !s.SessionStart.IsZero() {
+		fmt.Fprintf(w, "  Session:      %s (%s ago)\n", s.SessionStart.Format(time.RFC3339), time.Since(s.SessionStart).Round(time.Second))
+	}
+	if len(s.LoginFailures) > 0 {
+		fmt.Fprintf(w, "  Login errors: %s\n", formatCounters(s.LoginFailures))
+	}
+	if len(s.Reconnects) > 0 {
+		fmt.Fprintf(w, "  Reconnects:   %s\n", formatCounters(s.Reconnects))
+	}
+
+	if st := s.Stats; st != nil {
+		if !st.ConnectedAt.IsZero() {
+			fmt.Fprintf(w, "  Connected:    %s (%s ago)\n", st.ConnectedAt.Format(time.RFC3339), time.Since(st.ConnectedAt).Round(time.Second))
+		}
+		fmt.Fprintf(w, "  Received:     %d bytes, ipv4 %d packets, ipv6 %d packets, ppp %d frames\n", st.Rx.Tunnel.Bytes, st.Rx.IPv4.Packets, st.Rx.IPv6.Packets, st.Rx.PPP.Packets)
+		fmt.Fprintf(w, "  Sent:         %d bytes, ipv4 %d packets, ipv6 %d packets, ppp %d frames\n", st.Tx.Tunnel.Bytes, st.Tx.IPv4.Packets, st.Tx.IPv6.Packets, st.Tx.PPP.Packets)
+		if st.DecodeErrors > 0 || st.TunWriteErrors > 0 {
+			fmt.Fprintf(w, "  Errors:       decode %d, tun write %d\n", st.DecodeErrors, st.TunWriteErrors)
+		}
+		if st.EchoRTT > 0 {
+			fmt.Fprintf(w, "  Echo RTT:     %s\n", st.EchoRTT)
+		}
+	}
+}
+
+func printDNSStats(w io.Writer, resp *control.Response) {
+	if len(resp.DNSZones) == 0 && !resp.DNSQueryLog {
+		return
+	}
+
+	state := "off"
+	if resp.DNSQueryLog {
+		state = "on"
+	}
+	fmt.Fprintf(w, "DNS proxy: query log %s\n", state)
+	for _, v := range resp.DNSZones {
+		fmt.Fprintf(w, "  %-30s %d queries, %d cached, %d failed\n", v.Zone, v.Queries, v.Cached, v.Failed)
+	}
+}
+
+// formatCounters formats the counters as a sorted "key count" list
+func formatCounters(m map[string]uint64) string {
+	res := make([]string, 0, len(m))
+	for k, v := range m {
+		res = append(res, fmt.Sprintf("%s %d", k, v))
+	}
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/decode"
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+// decodeCommand decodes an F5 stream, a debug log or a capture file
+func decodeCommand(args []string) error {
+	var format string
+
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fs.StringVar(&format, "format", decode.FormatAuto, fmt.Sprintf("Input format: %s", strings.Join(decode.Formats, ", ")))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gof5 decode [--format format] [file]\n")
+		fmt.Fprintf(fs.Output(), "Reads stdin, when the file is not specified\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !util.StrSliceContains(decode.Formats, format) {
+		return fmt.Errorf("unsupported %q input format, supported formats: %s", format, strings.Join(decode.Formats, ", "))
+	}
+
+	in := os.Stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %s", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	return decode.Decode(in, os.Stdout, format)
+}
@@ -0,0 +1,151 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// execCommand runs a command inside the gof5 network namespace as the
+// invoking sudo user
+func execCommand(args []string) error {
+	var name string
+
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	fs.StringVar(&name, "netns", "", "Network namespace name, defaults to the netns config value")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gof5 exec [--netns name] -- command [args...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("command is not specified")
+	}
+
+	cfg, err := config.ReadConfig(false)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = cfg.Netns
+	}
+	if name == "" {
+		return fmt.Errorf("network namespace is not specified, use --netns flag or netns config option")
+	}
+
+	// namespaces are per thread, the command will be executed from this thread
+	runtime.LockOSThread()
+
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %q network namespace: %s", name, err)
+	}
+	err = netns.Set(ns)
+	ns.Close()
+	if err != nil {
+		return fmt.Errorf("failed to enter %q network namespace: %s", name, err)
+	}
+
+	// bind mount the namespace specific resolv.conf the same way as "ip netns exec" does
+	if err = unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("failed to unshare mount namespace: %s", err)
+	}
+	if err = unix.Mount("", "/", "none", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to remount root as slave: %s", err)
+	}
+	resolvConf := filepath.Join("/etc/netns", name, "resolv.conf")
+	if _, err = os.Stat(resolvConf); err == nil {
+		if err = unix.Mount(resolvConf, "/etc/resolv.conf", "none", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind mount %s: %s", resolvConf, err)
+		}
+	}
+
+	env := os.Environ()
+	if cfg.Uid != os.Geteuid() {
+		env, err = dropPrivileges(cfg.Uid, cfg.Gid, env)
+		if err != nil {
+			return err
+		}
+	}
+
+	path, err := exec.LookPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(path, fs.Args(), env)
+}
+
+// dropPrivileges switches the process credentials to the sudo user and
+// adjusts the user specific environment variables
+func dropPrivileges(uid, gid int, env []string) ([]string, error) {
+	usr, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %d user: %s", uid, err)
+	}
+
+	if err = syscall.Setgroups(userGroups(usr, gid)); err != nil {
+		return nil, fmt.Errorf("failed to set supplementary groups: %s", err)
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return nil, fmt.Errorf("failed to set %d GID: %s", gid, err)
+	}
+	if err = syscall.Setuid(uid); err != nil {
+		return nil, fmt.Errorf("failed to set %d UID: %s", uid, err)
+	}
+
+	return setEnv(env, map[string]string{
+		"HOME":    usr.HomeDir,
+		"USER":    usr.Username,
+		"LOGNAME": usr.Username,
+	}), nil
+}
+
+// userGroups returns the user supplementary groups, or the primary group, when
+// they cannot be detected
+func userGroups(usr *user.User, gid int) []int {
+	groups := []int{gid}
+	if ids, err := usr.GroupIds(); err == nil {
+		groups = groups[:0]
+		for _, v := range ids {
+			if id, err := strconv.Atoi(v); err == nil {
+				groups = append(groups, id)
+			}
+		}
+	}
+	return groups
+}
+
+// setEnv overrides environment variables
+func setEnv(env []string, vars map[string]string) []string {
+	res := make([]string, 0, len(env)+len(vars))
+	for _, v := range env {
+		if k := strings.SplitN(v, "=", 2); len(k) == 2 {
+			if _, ok := vars[k[0]]; ok {
+				continue
+			}
+		}
+		res = append(res, v)
+	}
+	for k, v := range vars {
+		res = append(res, k+"="+v)
+	}
+	return res
+}
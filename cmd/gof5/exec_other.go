@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+)
+
+func execCommand(_ []string) error {
+	return fmt.Errorf("gof5 exec is supported only in Linux")
+}
@@ -6,11 +6,15 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/howeyc/gopass"
 	"github.com/kayrus/gof5/pkg/client"
+	"github.com/kayrus/gof5/pkg/logging"
+	"github.com/kayrus/gof5/pkg/privsep"
 )
 
 var (
@@ -18,21 +22,62 @@ var (
 	info    = fmt.Sprintf("gof5 %s compiled with %s for %s/%s", Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 )
 
+// stringSlice is a flag, which can be specified multiple times
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func fatal(err error) {
 	if runtime.GOOS == "windows" {
 		// Escalated privileges in windows opens a new terminal, and if there is an
 		// error, it is impossible to see it. Thus we wait for user to press a button.
-		log.Printf("%s, press enter to exit", err)
+		slog.Error(fmt.Sprintf("%s, press enter to exit", err))
 		bufio.NewReader(os.Stdin).ReadBytes('\n')
 		os.Exit(1)
 	}
-	log.Fatal(err)
+	slog.Error(err.Error())
+	os.Exit(1)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "exec":
+			if err := execCommand(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "decode":
+			if err := decodeCommand(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "status", "disconnect", "reconnect":
+			if err := controlCommand(os.Args[1], os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "dns-log":
+			if err := dnsLogCommand(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		}
+	}
+
 	var version bool
 	var opts client.Options
 	var passwordStdin bool
+	var logOpts logging.Options
+	var debugSubsystems string
+	var usePrivsep bool
 
 	flag.StringVar(&opts.Server, "server", "", "")
 	flag.StringVar(&opts.Username, "username", "", "")
@@ -43,11 +88,21 @@ func main() {
 	flag.StringVar(&opts.Cert, "cert", "", "Path to a user TLS certificate")
 	flag.StringVar(&opts.Key, "key", "", "Path to a user TLS key")
 	flag.BoolVar(&opts.CloseSession, "close-session", false, "Close HTTPS VPN session on exit")
-	flag.BoolVar(&opts.Debug, "debug", false, "Show debug logs")
+	flag.BoolVar(&opts.Debug, "debug", false, "Show debug logs of all subsystems")
+	flag.StringVar(&debugSubsystems, "debug-subsystems", "", fmt.Sprintf("Comma separated list of subsystems to show debug logs for: %s", strings.Join(logging.Subsystems, ", ")))
+	flag.StringVar(&logOpts.Level, "log-level", "info", "Log level: debug, info, warn or error")
+	flag.StringVar(&logOpts.Format, "log-format", logging.FormatText, "Log format: text, json or journald")
 	flag.BoolVar(&opts.Sel, "select", false, "Select a server from available F5 servers")
 	flag.IntVar(&opts.ProfileIndex, "profile-index", 0, "If multiple VPN profiles are found chose profile n")
 	flag.BoolVar(&opts.NoStoreCookies, "no-store-cookies", false, "Do not persist session cookies on disk")
 	flag.BoolVar(&opts.CookieKeyStdin, "cookie-key-stdin", false, "Read cookie encryption key from stdin (hidden)")
+	flag.StringVar(&opts.Pcap, "pcap", "", "Capture tunnel packets and PPP control frames into a pcapng file")
+	flag.IntVar(&opts.PcapRotateSize, "pcap-rotate-size", 0, "Rotate the pcapng file after reaching the size in megabytes")
+	flag.IntVar(&opts.PcapRotateFiles, "pcap-rotate-files", 5, "Amount of rotated pcapng files to keep")
+	flag.Var((*stringSlice)(&opts.Pins), "pin", "SPKI SHA-256 pin of the server or its CA public key, can be specified multiple times")
+	flag.BoolVar(&opts.TOFU, "tofu", false, "Trust the server public key on first use and verify it on subsequent connections")
+	flag.StringVar(&opts.TLSKeyLog, "tls-keylog", "", "Write TLS keys into a file to decrypt the traffic in Wireshark, SSLKEYLOGFILE is used when empty (insecure)")
+	flag.BoolVar(&usePrivsep, "privsep", false, "Configure the network in a privileged helper and run the rest as the sudo user (Linux only)")
 	flag.BoolVar(&version, "version", false, "Show version and exit cleanly")
 
 	flag.Parse()
@@ -61,13 +116,39 @@ func main() {
 		fatal(fmt.Errorf("profile-index cannot be negative"))
 	}
 
+	if opts.PcapRotateSize < 0 || opts.PcapRotateFiles < 0 {
+		fatal(fmt.Errorf("pcap rotation parameters cannot be negative"))
+	}
+
+	logOpts.Debug = logging.ParseSubsystems(debugSubsystems)
+	if opts.Debug && len(logOpts.Debug) == 0 {
+		logOpts.Debug = logging.Subsystems
+	}
+	if err := logging.Setup(logOpts); err != nil {
+		fatal(err)
+	}
+
+	helper, err := privsep.FromEnv()
+	if err != nil {
+		fatal(err)
+	}
+	if helper == nil && usePrivsep {
+		code, err := runPrivsep()
+		if err != nil {
+			fatal(err)
+		}
+		os.Exit(code)
+	}
+	// the unprivileged process, started by the helper
+	opts.Helper = helper
+
 	log.Print(info)
 
 	if opts.Password != "" {
-		log.Print("Warning: --password is insecure and may be visible in process lists or shell history")
+		slog.Warn("--password is insecure and may be visible in process lists or shell history")
 	}
 	if opts.SessionID != "" {
-		log.Print("Warning: --session is insecure and may be visible in process lists or shell history")
+		slog.Warn("--session is insecure and may be visible in process lists or shell history")
 	}
 
 	if opts.Password == "" {
@@ -104,8 +185,10 @@ func main() {
 		opts.CookieKey = string(v)
 	}
 
-	if err := checkPermissions(); err != nil {
-		fatal(err)
+	if helper == nil {
+		if err := checkPermissions(); err != nil {
+			fatal(err)
+		}
 	}
 
 	if flag.NArg() > 0 {
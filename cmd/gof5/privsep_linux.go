@@ -0,0 +1,185 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/privsep"
+)
+
+// runPrivsep starts gof5 as the sudo user and serves its network configuration
+// requests as the privileged helper, returns the unprivileged process exit code
+func runPrivsep() (int, error) {
+	if os.Geteuid() != 0 {
+		return 0, fmt.Errorf("privilege separation requires gof5 to run as root")
+	}
+
+	cfg, err := config.ReadConfig(false)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.Uid == 0 {
+		return 0, fmt.Errorf("privilege separation requires an unprivileged user, run gof5 with sudo")
+	}
+
+	policy, err := newPolicy(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	usr, err := user.LookupId(strconv.Itoa(cfg.Uid))
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup %d user: %s", cfg.Uid, err)
+	}
+	var groups []uint32
+	for _, v := range userGroups(usr, cfg.Gid) {
+		groups = append(groups, uint32(v))
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect gof5 executable path: %s", err)
+	}
+
+	conn, f, err := privsep.Pair()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// the socket becomes the file descriptor 3
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = setEnv(os.Environ(), map[string]string{
+		privsep.EnvFD: "3",
+		"HOME":        usr.HomeDir,
+		"USER":        usr.Username,
+		"LOGNAME":     usr.Username,
+	})
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid:    uint32(cfg.Uid),
+			Gid:    uint32(cfg.Gid),
+			Groups: groups,
+		},
+		// terminate the unprivileged process, when the helper dies
+		Pdeathsig: syscall.SIGTERM,
+	}
+
+	err = cmd.Start()
+	f.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start unprivileged process: %s", err)
+	}
+	log.Printf("Started unprivileged process %d as %q user", cmd.Process.Pid, usr.Username)
+
+	// the helper waits for the unprivileged process to restore the network
+	// configuration, the terminal interrupt is sent to both processes
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+	go func() {
+		for sig := range sigChan {
+			if sig != syscall.SIGINT {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	serveErr := privsep.Serve(conn, policy)
+	if serveErr != nil {
+		// the unprivileged process doesn't follow the protocol
+		cmd.Process.Kill()
+	}
+
+	err = cmd.Wait()
+	if serveErr != nil {
+		return 0, serveErr
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code > 0 {
+			return code, nil
+		}
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// newPolicy returns the helper policy, which allows the config routes, zones
+// and interface names in addition to the server profile. The profile is sent
+// by the unprivileged process, therefore it is limited by the privsep allowed
+// lists.
+func newPolicy(cfg *config.Config) (*privsep.Policy, error) {
+	if cfg.Driver != "wireguard" {
+		return nil, fmt.Errorf("privilege separation requires the wireguard driver")
+	}
+	if cfg.NetConfig != config.NetConfigBuiltin {
+		return nil, fmt.Errorf("privilege separation cannot be used together with vpnc-script")
+	}
+	if cfg.Netns != "" || cfg.PolicyRouting.Table != 0 {
+		return nil, fmt.Errorf("privilege separation cannot be used together with a network namespace or policy routing")
+	}
+
+	if len(cfg.DNSZones) > 0 {
+		return nil, fmt.Errorf("privilege separation cannot be used together with dnsZones")
+	}
+	if cfg.FilterAAAA != "" {
+		return nil, fmt.Errorf("privilege separation cannot be used together with filterAAAA")
+	}
+	if cfg.Privsep.AllowedRoutes == nil || len(cfg.Privsep.AllowedRoutes.GetNetworks()) == 0 {
+		return nil, fmt.Errorf("privilege separation requires privsep.allowedRoutes")
+	}
+
+	policy := &privsep.Policy{
+		Zones:         cfg.DNS,
+		MaxInterfaces: 1,
+		RewriteResolv: cfg.RewriteResolv,
+	}
+	if cfg.TunName != "" {
+		policy.TunNames = append(policy.TunNames, cfg.TunName)
+	}
+	if cfg.Routes != nil {
+		policy.Routes = append(policy.Routes, cfg.Routes.GetNetworks()...)
+	}
+	policy.AllowedRoutes = cfg.Privsep.AllowedRoutes.GetNetworks()
+	if cfg.Privsep.AllowedDNS != nil {
+		policy.AllowedDNS = cfg.Privsep.AllowedDNS.GetNetworks()
+	}
+	policy.AllowedSuffixes = cfg.Privsep.AllowedSuffixes
+	for _, c := range cfg.Connections {
+		if c.TunName != "" {
+			policy.TunNames = append(policy.TunNames, c.TunName)
+		}
+		if c.Routes != nil {
+			policy.Routes = append(policy.Routes, c.Routes.GetNetworks()...)
+		}
+		if len(c.DNSZones) > 0 {
+			return nil, fmt.Errorf("privilege separation cannot be used together with dnsZones")
+		}
+		policy.Zones = append(policy.Zones, c.DNS...)
+	}
+	if len(cfg.Connections) > 1 {
+		policy.MaxInterfaces = len(cfg.Connections)
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+)
+
+func runPrivsep() (int, error) {
+	return 0, fmt.Errorf("privilege separation is supported only in Linux")
+}
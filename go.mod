@@ -12,12 +12,16 @@ require (
 	github.com/miekg/dns v1.1.40
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pion/dtls/v2 v2.2.4
+	github.com/vishvananda/netlink v1.1.0
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
 	github.com/zaninime/go-hdlc v1.1.1
 	golang.org/x/crypto v0.45.0
 	golang.org/x/net v0.47.0
 	golang.org/x/sys v0.38.0
+	golang.zx2c4.com/wireguard v0.0.0-20211028114750-eb6302c7eb71
 	gopkg.in/yaml.v2 v2.4.0
 	kernel.org/pub/linux/libs/security/libcap/cap v1.2.48
+	kernel.org/pub/linux/libs/security/libcap/psx v1.2.48
 )
 
 require (
@@ -33,12 +37,8 @@ require (
 	github.com/pion/udp v0.1.4 // indirect
 	github.com/sigurn/crc16 v0.0.0-20160107003519-da416fad5162 // indirect
 	github.com/sigurn/utils v0.0.0-20151230205143-f19e41f79f8f // indirect
-	github.com/vishvananda/netlink v1.1.0 // indirect
-	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df // indirect
 	golang.org/x/term v0.37.0 // indirect
-	golang.zx2c4.com/wireguard v0.0.0-20211028114750-eb6302c7eb71 // indirect
 	golang.zx2c4.com/wireguard/windows v0.5.2-0.20211028141252-9fe93eaf9c4a // indirect
 	gopkg.in/fsnotify.v1 v1.4.7 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
-	kernel.org/pub/linux/libs/security/libcap/psx v1.2.48 // indirect
 )
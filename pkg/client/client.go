@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,15 +13,29 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/control"
 	"github.com/kayrus/gof5/pkg/cookie"
 	"github.com/kayrus/gof5/pkg/link"
+	"github.com/kayrus/gof5/pkg/logging"
+	"github.com/kayrus/gof5/pkg/metrics"
+	"github.com/kayrus/gof5/pkg/pin"
+	"github.com/kayrus/gof5/pkg/proxy"
+	"github.com/kayrus/gof5/pkg/sdnotify"
+
+	"github.com/IBM/netaddr"
+	"github.com/fatih/color"
 )
 
+// promptMutex serializes interactive prompts of simultaneous connections
+var promptMutex sync.Mutex
+
 type Options struct {
 	config.Config
 	Server         string
@@ -40,6 +55,18 @@ type Options struct {
 	NoStoreCookies bool
 	CookieKeyStdin bool
 	CookieKey      string
+	// capture tunnel packets into a pcapng file
+	Pcap string
+	// rotate the capture file after reaching the size in megabytes
+	PcapRotateSize int
+	// amount of rotated capture files to keep
+	PcapRotateFiles int
+	// SPKI SHA-256 pins of the server or its CA public keys
+	Pins []string
+	// trust the server public key on first use
+	TOFU bool
+	// write TLS keys into a file, SSLKEYLOGFILE is used when empty
+	TLSKeyLog string
 }
 
 func UrlHandlerF5Vpn(opts *Options, s string) error {
@@ -99,9 +126,163 @@ func UrlHandlerF5Vpn(opts *Options, s string) error {
 }
 
 func Connect(ctx context.Context, opts *Options) error {
+	// read config
+	cfg, err := config.ReadConfig(opts.Debug)
+	if err != nil {
+		return err
+	}
+	cfg.Pcap = opts.Pcap
+	cfg.PcapMaxSize = int64(opts.PcapRotateSize) << 20
+	cfg.PcapMaxFiles = opts.PcapRotateFiles
+	// the network is configured by the privileged helper
+	cfg.Helper = opts.Helper
+
+	if path := opts.TLSKeyLog; path != "" || os.Getenv("SSLKEYLOGFILE") != "" {
+		if path == "" {
+			path = os.Getenv("SSLKEYLOGFILE")
+		}
+		f, err := openKeyLog(path, cfg.Uid, cfg.Gid)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cfg.TLS.KeyLog = f
+	}
+
+	// control API
+	ctrl := control.New()
+	if err := ctrl.Listen(filepath.Join(cfg.Path, control.SocketName), cfg.Uid, cfg.Gid); err != nil {
+		log.Printf("Control socket is disabled: %s", err)
+	}
+	defer ctrl.Close()
+
+	if interval := sdnotify.WatchdogInterval(); interval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		log.Printf("Enabling systemd watchdog with %s interval", interval)
+		go watchdog(ctx, ctrl, interval)
+	}
+
+	if cfg.MetricsListen != "" {
+		srv, err := metrics.Listen(cfg.MetricsListen, ctrl)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+
+	if opts.Server == "" && len(cfg.Connections) > 0 {
+		return connectAll(ctx, opts, cfg, ctrl)
+	}
+
+	// establish a single connection, referenced by its name
+	for _, c := range cfg.Connections {
+		if c.Name == opts.Server {
+			cfg.Connections = []config.Connection{c}
+			return connectAll(ctx, opts, cfg, ctrl)
+		}
+	}
+
+	return connect(ctx, opts, cfg, ctrl)
+}
+
+// openKeyLog opens the TLS key log file
+func openKeyLog(path string, uid, gid int) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q TLS key log file: %s", path, err)
+	}
+	// windows preserves the original user parameters, no need to chown
+	if runtime.GOOS != "windows" {
+		if err = f.Chown(uid, gid); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to set an owner for the %q TLS key log file: %s", path, err)
+		}
+	}
+
+	warn := log.New(color.Error, "", log.LstdFlags)
+	warn.Print(color.HiRedString("WARNING: TLS key logging is enabled, anyone with access to %q can decrypt the VPN traffic", path))
+
+	return f, nil
+}
+
+// connectAll establishes all configured connections simultaneously
+func connectAll(ctx context.Context, opts *Options, cfg *config.Config, ctrl *control.Server) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// stop all connections, including the ones, which are not established yet
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(termChan)
+	go func() {
+		select {
+		case <-termChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(cfg.Connections))
+	for i, c := range cfg.Connections {
+		o := *opts
+		o.Server = c.Server
+		o.Username = c.Username
+		o.ProfileIndex = c.ProfileIndex
+		o.ProfileName = c.ProfileName
+		if len(cfg.Connections) > 1 {
+			// don't send the same credentials to different servers
+			o.Password = ""
+			o.SessionID = ""
+		}
+
+		connCfg := *cfg
+		connCfg.Connections = nil
+		connCfg.Name = c.Name
+		connCfg.TunName = c.TunName
+		connCfg.DNS = c.DNS
+		connCfg.Routes = c.Routes
+		connCfg.ServerIPs = c.ServerIPs
+		if len(c.Pins) > 0 {
+			connCfg.Pins = c.Pins
+		}
+		if len(c.DNSZones) > 0 {
+			connCfg.DNSZones = c.DNSZones
+		}
+		if connCfg.Routes == nil && cfg.Routes != nil {
+			// routes are modified by each connection
+			connCfg.Routes = cfg.Routes.Union(&netaddr.IPSet{})
+		}
+		if cfg.Pcap != "" {
+			ext := filepath.Ext(cfg.Pcap)
+			connCfg.Pcap = strings.TrimSuffix(cfg.Pcap, ext) + "-" + c.Name + ext
+		}
+
+		wg.Add(1)
+		go func(i int, o *Options, cfg *config.Config) {
+			defer wg.Done()
+			log.Printf("Starting %q connection to %s", cfg.Name, o.Server)
+			if err := connect(ctx, o, cfg, ctrl); err != nil {
+				errs[i] = fmt.Errorf("%q connection: %s", cfg.Name, err)
+				log.Print(errs[i])
+				return
+			}
+			log.Printf("%q connection is closed", cfg.Name)
+		}(i, &o, &connCfg)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// connect establishes a single VPN connection
+func connect(ctx context.Context, opts *Options, cfg *config.Config, ctrl *control.Server) (err error) {
 	if opts.Server == "" {
+		promptMutex.Lock()
 		fmt.Print("Enter server address: ")
 		fmt.Scanln(&opts.Server)
+		promptMutex.Unlock()
 	}
 
 	u, err := url.Parse(opts.Server)
@@ -125,11 +306,42 @@ func Connect(ctx context.Context, opts *Options) error {
 	}
 	opts.Server = u.Host
 
-	// read config
-	cfg, err := config.ReadConfig(opts.Debug)
+	notify(sdnotify.Status("Connecting to %s", opts.Server))
+
+	// register the connection in the control API
+	sess := newSession(opts.Server, cfg)
+	ctrl.Register(cfg.Name, sess)
+	defer func() {
+		if ctx.Err() != nil {
+			// the connection is stopped by a signal or the parent,
+			// including the login and the tunnel setup
+			err = nil
+		}
+		if err != nil {
+			// keep the failed connection, its status and counters are
+			// still exposed through the control API and the metrics,
+			// until gof5 exits, i.e. the other connections are closed
+			sess.failed(err)
+			return
+		}
+		ctrl.Unregister(cfg.Name)
+	}()
+
+	cfg.ProxyURL, err = proxy.URL(cfg.Proxy, opts.Server)
+	if err != nil {
+		return err
+	}
+
+	// connections share the config pins backing array
+	pins := append(append([]string(nil), cfg.Pins...), opts.Pins...)
+	cfg.PinVerifier, err = pin.New(pins, cfg.TOFU || opts.TOFU, filepath.Join(cfg.Path, pin.KnownServersName), cfg.Uid, cfg.Gid)
 	if err != nil {
 		return err
 	}
+	if cfg.PinVerifier != nil {
+		cfg.PinVerifier.Confirm = confirmServer
+	}
+
 	opts.Config = *cfg
 	allowPlaintextCookies := os.Getenv("GOF5_ALLOW_PLAINTEXT_COOKIES") == "1"
 
@@ -158,10 +370,22 @@ func Connect(ctx context.Context, opts *Options) error {
 	if err != nil {
 		return fmt.Errorf("failed to build TLS config: %v", err)
 	}
+	if cfg.PinVerifier != nil {
+		// the server public key is verified against the pins and the known
+		// servers in addition to the CA and the hostname
+		tlsConf.VerifyConnection = cfg.PinVerifier.VerifyConnection
+	}
 	transport := &http.Transport{
 		TLSClientConfig: tlsConf,
 	}
-	if opts.Debug {
+	if cfg.ProxyURL != nil {
+		log.Printf("Using %s proxy", cfg.ProxyURL.Redacted())
+		transport.Proxy = http.ProxyURL(cfg.ProxyURL)
+	} else if len(cfg.ServerIPs) > 0 {
+		log.Printf("Using %q addresses for %s", cfg.ServerIPs, u.Hostname())
+		transport.DialContext = link.DialContext(u.Hostname(), cfg.ServerIPs)
+	}
+	if logging.Enabled(logging.HTTP) {
 		client.Transport = &RoundTripper{
 			Rt:     transport,
 			Logger: &logger{},
@@ -172,7 +396,7 @@ func Connect(ctx context.Context, opts *Options) error {
 
 	// when server select list has been chosen
 	if opts.Sel {
-		u, err = getServersList(client, opts.Server)
+		u, err = getServersList(ctx, client, opts.Server)
 		if err != nil {
 			return err
 		}
@@ -180,20 +404,27 @@ func Connect(ctx context.Context, opts *Options) error {
 	}
 
 	// read cookies
+	logging.AddSecret(opts.SessionID)
 	if err := cookie.ReadCookies(client, u, cfg, opts.SessionID, []byte(opts.CookieKey)); err != nil {
 		return err
 	}
+	for _, v := range client.Jar.Cookies(u) {
+		if v.Name == "MRHSession" {
+			logging.AddSecret(v.Value)
+		}
+	}
 
 	if len(client.Jar.Cookies(u)) == 0 {
 		// need to login
-		if err := login(client, opts.Server, &opts.Username, &opts.Password); err != nil {
+		if err := login(ctx, client, opts.Server, &opts.Username, &opts.Password); err != nil {
+			sess.loginFailed(err)
 			return fmt.Errorf("failed to login: %s", err)
 		}
 	} else {
 		log.Printf("Reusing saved HTTPS VPN session for %s", u.Host)
 	}
 
-	resp, err := getProfiles(client, opts.Server)
+	resp, err := getProfiles(ctx, client, opts.Server)
 	if err != nil {
 		return fmt.Errorf("failed to get VPN profiles: %s", err)
 	}
@@ -206,12 +437,13 @@ func Connect(ctx context.Context, opts *Options) error {
 		}
 		resp.Body.Close()
 
-		if err := login(client, opts.Server, &opts.Username, &opts.Password); err != nil {
+		if err := login(ctx, client, opts.Server, &opts.Username, &opts.Password); err != nil {
+			sess.loginFailed(err)
 			return fmt.Errorf("failed to login: %s", err)
 		}
 
 		// new request
-		resp, err = getProfiles(client, opts.Server)
+		resp, err = getProfiles(ctx, client, opts.Server)
 		if err != nil {
 			return fmt.Errorf("failed to get VPN profiles: %s", err)
 		}
@@ -229,12 +461,13 @@ func Connect(ctx context.Context, opts *Options) error {
 
 		// An expired session in a cookie may cause parsing failure.
 		// try again relogin
-		if err := login(client, opts.Server, &opts.Username, &opts.Password); err != nil {
+		if err := login(ctx, client, opts.Server, &opts.Username, &opts.Password); err != nil {
+			sess.loginFailed(err)
 			return fmt.Errorf("failed to login: %s", err)
 		}
 
 		// new request
-		resp, err = getProfiles(client, opts.Server)
+		resp, err = getProfiles(ctx, client, opts.Server)
 		if err != nil {
 			return fmt.Errorf("failed to get VPN profiles: %s", err)
 		}
@@ -246,10 +479,12 @@ func Connect(ctx context.Context, opts *Options) error {
 	}
 
 	// read config, returned by F5
-	cfg.F5Config, err = getConnectionOptions(client, opts, profile)
+	cfg.F5Config, err = getConnectionOptions(ctx, client, opts, profile)
 	if err != nil {
 		return fmt.Errorf("failed to get VPN connection options: %s", err)
 	}
+	logging.AddSecret(cfg.F5Config.Object.SessionID)
+	sess.started()
 
 	// save cookies
 	if !opts.NoStoreCookies {
@@ -266,17 +501,37 @@ func Connect(ctx context.Context, opts *Options) error {
 		defer closeVPNSession(client, opts.Server)
 	}
 
+	// establish the tunnel, reestablish it on a reconnect request
+	for {
+		reason, err := tunnel(ctx, opts, cfg, tlsConf, sess)
+		if reason == "" {
+			return err
+		}
+		sess.reconnected(reason)
+		log.Printf("Reconnecting to %s", opts.Server)
+		notify(sdnotify.Status("Reconnecting to %s", opts.Server))
+	}
+}
+
+// tunnel establishes the VPN tunnel and forwards the traffic until an error,
+// a signal or a control request. It returns the reconnect reason, when the
+// tunnel should be reestablished.
+func tunnel(ctx context.Context, opts *Options, cfg *config.Config, tlsConf *tls.Config, sess *session) (string, error) {
 	// TLS
-	l, err := link.InitConnection(opts.Server, cfg, tlsConf)
+	l, err := link.InitConnection(ctx, opts.Server, cfg, tlsConf)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer l.HTTPConn.Close()
 
+	sess.setLink(l)
+	defer sess.setLink(nil)
+
 	cmd := link.Cmd(cfg)
 
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE, syscall.SIGHUP)
+	defer signal.Stop(termChan)
 
 	// set routes and DNS after the PPP/TUN is up
 	go l.WaitAndConfig(cfg)
@@ -298,7 +553,7 @@ func Connect(ctx context.Context, opts *Options) error {
 			*/
 			stderr, err := cmd.StderrPipe()
 			if err != nil {
-				return fmt.Errorf("cannot allocate stderr pipe: %s", err)
+				return "", fmt.Errorf("cannot allocate stderr pipe: %s", err)
 			}
 			// pppd log parser
 			go l.PppdLogParser(stderr)
@@ -306,16 +561,16 @@ func Connect(ctx context.Context, opts *Options) error {
 
 		stdin, err := cmd.StdinPipe()
 		if err != nil {
-			return fmt.Errorf("cannot allocate stdin pipe: %s", err)
+			return "", fmt.Errorf("cannot allocate stdin pipe: %s", err)
 		}
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
-			return fmt.Errorf("cannot allocate stdout pipe: %s", err)
+			return "", fmt.Errorf("cannot allocate stdout pipe: %s", err)
 		}
 
 		err = cmd.Start()
 		if err != nil {
-			return fmt.Errorf("failed to start pppd: %s", err)
+			return "", fmt.Errorf("failed to start pppd: %s", err)
 		}
 
 		// catch ppp/pppd child termination
@@ -332,22 +587,41 @@ func Connect(ctx context.Context, opts *Options) error {
 
 		// tun->http go routine
 		go l.TunToHTTP()
+
+		// LCP echo go routine
+		go l.LCPEcho()
 	}
 
+	if cfg.StatsInterval > 0 {
+		go l.LogStats(cfg.StatsInterval)
+	}
+
+	var reconnect string
 	select {
 	case <-ctx.Done():
+		// a signal or the parent stops the connection
 		log.Printf("context cancelled, exiting")
-		err = ctx.Err()
 	case sig := <-termChan:
 		log.Printf("received %s signal, exiting", sig)
+	case <-sess.disconnect:
+		log.Printf("disconnect requested, exiting")
+	case reconnect = <-sess.reconnect:
 	case err = <-l.ErrChan:
 		// error received
 	case err = <-l.PppdErrChan:
 		// ppp/pppd child error received
 	}
 
+	switch {
+	case reconnect != "":
+	case err != nil && ctx.Err() == nil:
+		notify(sdnotify.Status("Connection to %s failed: %s", opts.Server, err))
+	default:
+		notify(sdnotify.Stopping, sdnotify.Status("Disconnecting from %s", opts.Server))
+	}
+
 	// notify tun readers and writes to stop
 	close(l.TunDown)
 
-	return err
+	return reconnect, err
 }
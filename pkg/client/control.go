@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/control"
+	"github.com/kayrus/gof5/pkg/link"
+	"github.com/kayrus/gof5/pkg/sdnotify"
+)
+
+// reconnect reasons
+const reconnectControl = "control"
+
+// session is a VPN connection, controlled through the control socket
+type session struct {
+	sync.Mutex
+	server string
+	cfg    *config.Config
+	link   *link.Link
+	// the time, since the session has no link, i.e. is being established
+	noLinkSince time.Time
+	// the VPN session start time
+	start time.Time
+	// reconnects by reason
+	reconnects map[string]uint64
+	// login failures by error type
+	loginFailures map[string]uint64
+	// the connection failure
+	err        error
+	reconnect  chan string
+	disconnect chan struct{}
+	once       sync.Once
+}
+
+func newSession(server string, cfg *config.Config) *session {
+	return &session{
+		server:        server,
+		cfg:           cfg,
+		noLinkSince:   time.Now(),
+		reconnects:    make(map[string]uint64),
+		loginFailures: make(map[string]uint64),
+		reconnect:     make(chan string, 1),
+		disconnect:    make(chan struct{}),
+	}
+}
+
+// started records the VPN session start time
+func (s *session) started() {
+	s.Lock()
+	defer s.Unlock()
+	s.start = time.Now()
+}
+
+// reconnected counts the tunnel reconnect
+func (s *session) reconnected(reason string) {
+	s.Lock()
+	defer s.Unlock()
+	s.reconnects[reason]++
+}
+
+// loginFailed counts the login failure by its type
+func (s *session) loginFailed(err error) {
+	reason := loginErrorOther
+	if e, ok := err.(*loginError); ok {
+		reason = e.reason
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.loginFailures[reason]++
+}
+
+// failed records the connection failure
+func (s *session) failed(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err
+}
+
+// setLink sets the current session link
+func (s *session) setLink(l *link.Link) {
+	s.Lock()
+	defer s.Unlock()
+	s.link = l
+	if l == nil {
+		s.noLinkSince = time.Now()
+	} else {
+		var n uint64
+		for _, v := range s.reconnects {
+			n += v
+		}
+		l.SetReconnects(n)
+	}
+}
+
+// Status returns the session status
+func (s *session) Status() control.Status {
+	s.Lock()
+	l := s.link
+	status := control.Status{
+		Name:          s.cfg.Name,
+		Server:        s.server,
+		State:         control.StateConnecting,
+		DNSZones:      s.cfg.DNS,
+		SessionStart:  s.start,
+		Reconnects:    copyCounters(s.reconnects),
+		LoginFailures: copyCounters(s.loginFailures),
+	}
+	if s.err != nil {
+		status.State = control.StateFailed
+		status.Error = s.err.Error()
+	}
+	s.Unlock()
+
+	if l == nil {
+		return status
+	}
+
+	stats := l.Stats()
+	if !stats.ConnectedAt.IsZero() {
+		status.State = control.StateConnected
+	}
+	status.Stats = &stats
+
+	info := l.Info()
+	status.Interface = info.Interface
+	status.LocalIPv4 = info.LocalIPv4
+	status.ServerIPv4 = info.ServerIPv4
+	status.LocalIPv6 = info.LocalIPv6
+	status.ServerIPv6 = info.ServerIPv6
+	for _, v := range info.Routes {
+		status.Routes = append(status.Routes, v.String())
+	}
+	if s.cfg.F5Config != nil {
+		status.DNS = s.cfg.F5Config.Object.DNS
+		status.DNSSuffixes = s.cfg.F5Config.Object.DNSSuffix
+	}
+
+	return status
+}
+
+// alive reports whether the session link is alive or the session is being
+// established for less than the timeout
+func (s *session) alive(timeout time.Duration) bool {
+	s.Lock()
+	l, err, since := s.link, s.err, s.noLinkSince
+	s.Unlock()
+
+	if err != nil {
+		return false
+	}
+	if l == nil {
+		return time.Since(since) < timeout
+	}
+	return l.Alive()
+}
+
+// Reconnect requests the tunnel reconnect
+func (s *session) Reconnect() {
+	s.requestReconnect(reconnectControl)
+}
+
+func (s *session) requestReconnect(reason string) {
+	select {
+	case s.reconnect <- reason:
+	default:
+		// the reconnect is already requested
+	}
+}
+
+// Disconnect requests the session termination
+func (s *session) Disconnect() {
+	s.once.Do(func() {
+		close(s.disconnect)
+	})
+}
+
+func copyCounters(m map[string]uint64) map[string]uint64 {
+	if len(m) == 0 {
+		return nil
+	}
+	res := make(map[string]uint64, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// watchdog pings the systemd watchdog, while all sessions are alive
+func watchdog(ctx context.Context, ctrl *control.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		alive := true
+		for _, c := range ctrl.Connections() {
+			// the session must be established within the watchdog
+			// interval
+			if s, ok := c.(*session); ok && !s.alive(interval) {
+				log.Printf("%q connection is not alive, skipping the watchdog notification", s.cfg.Name)
+				alive = false
+			}
+		}
+		if alive {
+			notify(sdnotify.Watchdog)
+		}
+	}
+}
+
+// notify sends the notification to systemd, when gof5 is started as a
+// systemd service
+func notify(states ...string) {
+	if _, err := sdnotify.Notify(states...); err != nil {
+		log.Printf("Failed to notify systemd: %s", err)
+	}
+}
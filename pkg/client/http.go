@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/tls"
@@ -36,6 +37,12 @@ func tlsConfig(opts *Options, insecure bool) (*tls.Config, error) {
 	config := &tls.Config{
 		InsecureSkipVerify: insecure,
 		Renegotiation:      opts.Renegotiation,
+		MinVersion:         opts.TLS.MinVersionID,
+		MaxVersion:         opts.TLS.MaxVersionID,
+		CipherSuites:       opts.TLS.CipherSuiteIDs,
+		CurvePreferences:   opts.TLS.CurveIDs,
+		ServerName:         opts.TLS.ServerName,
+		KeyLogWriter:       opts.TLS.KeyLog,
 	}
 
 	if opts.CACert != "" {
@@ -239,22 +246,63 @@ func loginSignature(c *http.Client, server string, _, _ *string) error {
 	return nil
 }
 
-func login(c *http.Client, server string, username, password *string) error {
-	if *username == "" {
-		fmt.Print("Enter VPN username: ")
-		fmt.Scanln(username)
-	}
-	if *password == "" {
-		fmt.Print("Enter VPN password: ")
-		v, err := gopass.GetPasswd()
-		if err != nil {
-			return fmt.Errorf("failed to read password: %s", err)
+// confirmServer asks the user to trust the unknown server public key
+func confirmServer(host string, cert *x509.Certificate, pin string) bool {
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+
+	fmt.Printf("The authenticity of %s server can't be established.\n", host)
+	fmt.Printf("Subject: %s\n", cert.Subject)
+	fmt.Printf("Issuer: %s\n", cert.Issuer)
+	fmt.Printf("Expires: %s\n", cert.NotAfter)
+	fmt.Printf("Public key: %s\n", pin)
+	fmt.Print("Are you sure you want to trust this server (yes/no)? ")
+
+	var answer string
+	fmt.Scanln(&answer)
+	return strings.ToLower(answer) == "yes"
+}
+
+// login failure types
+const (
+	loginErrorPrompt      = "prompt"
+	loginErrorNetwork     = "network"
+	loginErrorCredentials = "credentials"
+	loginErrorExpired     = "session_expired"
+	loginErrorOther       = "other"
+)
+
+// loginError is a login failure of a specific type
+type loginError struct {
+	reason string
+	err    error
+}
+
+func (e *loginError) Error() string {
+	return e.err.Error()
+}
+
+func login(ctx context.Context, c *http.Client, server string, username, password *string) error {
+	if *username == "" || *password == "" {
+		promptMutex.Lock()
+		if *username == "" {
+			fmt.Printf("Enter %s VPN username: ", server)
+			fmt.Scanln(username)
 		}
-		*password = string(v)
+		if *password == "" {
+			fmt.Printf("Enter %s VPN password: ", server)
+			v, err := gopass.GetPasswd()
+			if err != nil {
+				promptMutex.Unlock()
+				return &loginError{loginErrorPrompt, fmt.Errorf("failed to read password: %s", err)}
+			}
+			*password = string(v)
+		}
+		promptMutex.Unlock()
 	}
 
 	log.Printf("Logging in...")
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s", server), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s", server), nil)
 	if err != nil {
 		return err
 	}
@@ -262,11 +310,11 @@ func login(c *http.Client, server string, username, password *string) error {
 	req.Header.Set("User-Agent", userAgent)
 	resp, err := c.Do(req)
 	if err != nil {
-		return err
+		return &loginError{loginErrorNetwork, err}
 	}
 	_, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return &loginError{loginErrorNetwork, err}
 	}
 	resp.Body.Close()
 
@@ -274,7 +322,7 @@ func login(c *http.Client, server string, username, password *string) error {
 	data.Set("username", *username)
 	data.Add("password", *password)
 	data.Add("vhost", "standard")
-	req, err = http.NewRequest("POST", fmt.Sprintf("https://%s/my.policy?outform=xml", server), strings.NewReader(data.Encode()))
+	req, err = http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s/my.policy?outform=xml", server), strings.NewReader(data.Encode()))
 	if err != nil {
 		return err
 	}
@@ -282,11 +330,11 @@ func login(c *http.Client, server string, username, password *string) error {
 	req.Header.Set("User-Agent", userAgent)
 	resp, err = c.Do(req)
 	if err != nil {
-		return err
+		return &loginError{loginErrorNetwork, err}
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return &loginError{loginErrorNetwork, err}
 	}
 	resp.Body.Close()
 
@@ -297,8 +345,11 @@ func login(c *http.Client, server string, username, password *string) error {
 	*/
 
 	// TODO: parse response 302 location and error code
-	if resp.StatusCode == 302 || bytes.Contains(body, []byte("Session Expired/Timeout")) || bytes.Contains(body, []byte("The username or password is not correct")) {
-		return fmt.Errorf("wrong credentials")
+	if bytes.Contains(body, []byte("Session Expired/Timeout")) {
+		return &loginError{loginErrorExpired, fmt.Errorf("wrong credentials")}
+	}
+	if resp.StatusCode == 302 || bytes.Contains(body, []byte("The username or password is not correct")) {
+		return &loginError{loginErrorCredentials, fmt.Errorf("wrong credentials")}
 	}
 
 	return nil
@@ -333,8 +384,8 @@ func parseProfile(reader io.ReadCloser, profileIndex int, profileName string) (s
 	return "", fmt.Errorf("VPN profile was not found")
 }
 
-func getProfiles(c *http.Client, server string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/vdesk/vpn/index.php3?outform=xml&client_version=2.0", server), nil)
+func getProfiles(ctx context.Context, c *http.Client, server string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/vdesk/vpn/index.php3?outform=xml&client_version=2.0", server), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a request: %s", err)
 	}
@@ -342,13 +393,16 @@ func getProfiles(c *http.Client, server string) (*http.Response, error) {
 	return c.Do(req)
 }
 
-func getConnectionOptions(c *http.Client, opts *Options, profile string) (*config.Favorite, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/vdesk/vpn/connect.php3?%s&outform=xml&client_version=2.0", opts.Server, profile), nil)
+func getConnectionOptions(ctx context.Context, c *http.Client, opts *Options, profile string) (*config.Favorite, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/vdesk/vpn/connect.php3?%s&outform=xml&client_version=2.0", opts.Server, profile), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a request: %s", err)
 	}
 	req.Header.Set("User-Agent", userAgent)
 	resp, err := c.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
 
 	if err != nil {
 		log.Printf("Failed to read a request: %s", err)
@@ -398,8 +452,8 @@ func closeVPNSession(c *http.Client, server string) {
 	defer resp.Body.Close()
 }
 
-func getServersList(c *http.Client, server string) (*url.URL, error) {
-	r, err := http.NewRequest("GET", fmt.Sprintf("https://%s/pre/config.php", server), nil)
+func getServersList(ctx context.Context, c *http.Client, server string) (*url.URL, error) {
+	r, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/pre/config.php", server), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a request to get servers list: %s", err)
 	}
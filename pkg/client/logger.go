@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/kayrus/gof5/pkg/logging"
 )
 
 // Logger is an interface representing the Logger struct
@@ -24,13 +25,13 @@ type logger struct {
 
 func (lg logger) RequestPrintf(format string, args ...interface{}) {
 	for _, v := range strings.Split(fmt.Sprintf(format, args...), "\n") {
-		log.Printf("-> %s", v)
+		logging.Debugf(logging.HTTP, "-> %s", v)
 	}
 }
 
 func (lg logger) ResponsePrintf(format string, args ...interface{}) {
 	for _, v := range strings.Split(fmt.Sprintf(format, args...), "\n") {
-		log.Printf("<- %s", v)
+		logging.Debugf(logging.HTTP, "<- %s", v)
 	}
 }
 
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,9 +11,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kayrus/gof5/pkg/pin"
 	"github.com/kayrus/gof5/pkg/util"
 
+	"github.com/miekg/dns"
 	"gopkg.in/yaml.v2"
 )
 
@@ -26,9 +31,28 @@ var (
 	// BSD systems don't support listeniing on 127.0.0.1+N
 	defaultBSDDNSListenAddr = net.IPv4(127, 0, 0, 1).To4()
 	supportedDrivers        = []string{"wireguard", "pppd"}
+	// vpnc-script default locations
+	vpncScriptPaths = []string{
+		"/usr/share/vpnc-scripts/vpnc-script",
+		"/etc/vpnc/vpnc-script",
+		"/usr/local/share/vpnc-scripts/vpnc-script",
+		"/usr/local/sbin/vpnc-script",
+	}
 )
 
-func ReadConfig(debug bool) (*Config, error) {
+const (
+	// right before the main table rule
+	defaultPolicyRoutingPriority = 32765
+	// reserved routing tables
+	rtTableDefault = 253
+	rtTableMain    = 254
+	rtTableLocal   = 255
+)
+
+const defaultHooksTimeout = 30 * time.Second
+
+// lookupUser returns the current user or the sudo user
+func lookupUser() (*user.User, error) {
 	var err error
 	var usr *user.User
 
@@ -51,6 +75,24 @@ func ReadConfig(debug bool) (*Config, error) {
 			return nil, fmt.Errorf("failed to detect home directory: %s", err)
 		}
 	}
+
+	return usr, nil
+}
+
+// Dir returns the config directory path
+func Dir() (string, error) {
+	usr, err := lookupUser()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, configDir), nil
+}
+
+func ReadConfig(debug bool) (*Config, error) {
+	usr, err := lookupUser()
+	if err != nil {
+		return nil, err
+	}
 	configPath := filepath.Join(usr.HomeDir, configDir)
 
 	var uid, gid int
@@ -111,6 +153,135 @@ func ReadConfig(debug bool) (*Config, error) {
 		return nil, fmt.Errorf("%q driver is unsupported, supported drivers are: %q", cfg.Driver, supportedDrivers)
 	}
 
+	if v := &cfg.PolicyRouting; v.Table != 0 {
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("policy routing is supported only in Linux")
+		}
+		switch v.Table {
+		case rtTableDefault, rtTableMain, rtTableLocal:
+			return nil, fmt.Errorf("policy routing cannot use the reserved %d routing table", v.Table)
+		}
+		if v.Table < 0 {
+			return nil, fmt.Errorf("invalid policy routing table: %d", v.Table)
+		}
+		if v.FwMark == 0 {
+			v.FwMark = v.Table
+		}
+		if v.Priority == 0 {
+			v.Priority = defaultPolicyRoutingPriority
+		}
+		if v.PreferLAN && v.Priority < 2 {
+			return nil, fmt.Errorf("policy routing priority must be greater than 1, when preferLAN is enabled")
+		}
+	}
+
+	if cfg.Netns != "" {
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("network namespaces are supported only in Linux")
+		}
+		if cfg.Driver != "wireguard" {
+			return nil, fmt.Errorf("network namespace requires the wireguard driver")
+		}
+		if cfg.PolicyRouting.Table != 0 {
+			return nil, fmt.Errorf("network namespace and policy routing cannot be used together")
+		}
+		if strings.ContainsRune(cfg.Netns, filepath.Separator) || cfg.Netns == "." || cfg.Netns == ".." {
+			return nil, fmt.Errorf("invalid %q network namespace name", cfg.Netns)
+		}
+	}
+
+	switch cfg.NetConfig {
+	case "":
+		cfg.NetConfig = NetConfigBuiltin
+	case NetConfigBuiltin:
+	case NetConfigVPNCScript:
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("vpnc-script is not supported in Windows")
+		}
+		if cfg.Netns != "" || cfg.PolicyRouting.Table != 0 {
+			return nil, fmt.Errorf("vpnc-script cannot be used together with a network namespace or policy routing")
+		}
+		if cfg.VPNCScript == "" {
+			for _, v := range vpncScriptPaths {
+				if _, err := os.Stat(v); err == nil {
+					cfg.VPNCScript = v
+					break
+				}
+			}
+			if cfg.VPNCScript == "" {
+				return nil, fmt.Errorf("vpnc-script is not found in %q, set the vpncScript path", vpncScriptPaths)
+			}
+		} else if _, err := os.Stat(cfg.VPNCScript); err != nil {
+			return nil, fmt.Errorf("failed to find vpnc-script: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("%q netconfig is unsupported, supported backends are: %s, %s", cfg.NetConfig, NetConfigBuiltin, NetConfigVPNCScript)
+	}
+
+	if err := validateDNSZones(cfg, cfg.DNSZones); err != nil {
+		return nil, err
+	}
+
+	switch cfg.FilterAAAA {
+	case "":
+	case FilterAAAAVPN, FilterAAAAAll:
+		if cfg.NetConfig == NetConfigVPNCScript || cfg.Netns != "" {
+			return nil, fmt.Errorf("filterAAAA cannot be used together with vpnc-script or a network namespace")
+		}
+	default:
+		return nil, fmt.Errorf("invalid %q filterAAAA mode, supported modes: %s, %s", cfg.FilterAAAA, FilterAAAAVPN, FilterAAAAAll)
+	}
+
+	if cfg.StatsInterval < 0 {
+		return nil, fmt.Errorf("statsInterval cannot be negative")
+	}
+
+	if cfg.MetricsListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.MetricsListen); err != nil {
+			return nil, fmt.Errorf("invalid %q metricsListen address: %s", cfg.MetricsListen, err)
+		}
+	}
+
+	if cfg.Hooks.Timeout < 0 {
+		return nil, fmt.Errorf("hooks timeout cannot be negative")
+	}
+	if cfg.Hooks.Timeout == 0 {
+		cfg.Hooks.Timeout = defaultHooksTimeout
+	}
+	switch cfg.Hooks.OnFailure {
+	case "":
+		cfg.Hooks.OnFailure = HooksAbort
+	case HooksAbort, HooksIgnore:
+	default:
+		return nil, fmt.Errorf("invalid %q hooks onFailure policy, supported policies: %s, %s", cfg.Hooks.OnFailure, HooksAbort, HooksIgnore)
+	}
+
+	if cfg.Sandbox {
+		if err := validateSandbox(cfg); err != nil {
+			return nil, err
+		}
+		// the sandbox allows to modify only the resolv.conf file, not to
+		// rename it within /etc
+		if !cfg.RewriteResolv {
+			log.Printf("Overriding rewriteResolv: false, the sandbox rewrites /etc/resolv.conf")
+			cfg.RewriteResolv = true
+		}
+	}
+
+	if err := parseTLS(&cfg.TLS); err != nil {
+		return nil, err
+	}
+
+	for _, v := range cfg.Pins {
+		if _, err := pin.Parse(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateConnections(cfg); err != nil {
+		return nil, err
+	}
+
 	if cfg.ListenDNS == nil {
 		switch runtime.GOOS {
 		case "freebsd",
@@ -129,3 +300,171 @@ func ReadConfig(debug bool) (*Config, error) {
 
 	return cfg, nil
 }
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	tlsCurves = map[string]tls.CurveID{
+		"X25519":         tls.X25519,
+		"X25519MLKEM768": tls.X25519MLKEM768,
+		"P256":           tls.CurveP256,
+		"P384":           tls.CurveP384,
+		"P521":           tls.CurveP521,
+	}
+)
+
+func parseTLS(t *TLS) error {
+	var ok bool
+	if t.MinVersion != "" {
+		if t.MinVersionID, ok = tlsVersions[t.MinVersion]; !ok {
+			return fmt.Errorf("unsupported %q TLS minVersion, supported versions are: 1.0, 1.1, 1.2, 1.3", t.MinVersion)
+		}
+	}
+	if t.MaxVersion != "" {
+		if t.MaxVersionID, ok = tlsVersions[t.MaxVersion]; !ok {
+			return fmt.Errorf("unsupported %q TLS maxVersion, supported versions are: 1.0, 1.1, 1.2, 1.3", t.MaxVersion)
+		}
+	}
+	if t.MinVersionID != 0 && t.MaxVersionID != 0 && t.MinVersionID > t.MaxVersionID {
+		return fmt.Errorf("TLS minVersion %s is greater than maxVersion %s", t.MinVersion, t.MaxVersion)
+	}
+
+	suites := make(map[string]uint16)
+	for _, v := range tls.CipherSuites() {
+		suites[v.Name] = v.ID
+	}
+	for _, v := range tls.InsecureCipherSuites() {
+		suites[v.Name] = v.ID
+	}
+	for _, v := range t.CipherSuites {
+		id, ok := suites[v]
+		if !ok {
+			return fmt.Errorf("unsupported %q TLS cipher suite", v)
+		}
+		t.CipherSuiteIDs = append(t.CipherSuiteIDs, id)
+	}
+
+	for _, v := range t.Curves {
+		id, ok := tlsCurves[v]
+		if !ok {
+			return fmt.Errorf("unsupported %q TLS curve, supported curves are: X25519, X25519MLKEM768, P256, P384, P521", v)
+		}
+		t.CurveIDs = append(t.CurveIDs, id)
+	}
+
+	return nil
+}
+
+// validateSandbox verifies that the sandbox doesn't block the configured
+// features, e.g. external commands cannot be executed
+func validateSandbox(cfg *Config) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("sandbox is supported only in Linux")
+	}
+	if cfg.Driver != "wireguard" {
+		return fmt.Errorf("sandbox requires the wireguard driver")
+	}
+	if cfg.NetConfig != NetConfigBuiltin {
+		return fmt.Errorf("sandbox cannot be used together with vpnc-script")
+	}
+	if cfg.Netns != "" {
+		return fmt.Errorf("sandbox cannot be used together with a network namespace")
+	}
+	if h := cfg.Hooks; len(h.PreUp)+len(h.PostUp)+len(h.PreDown)+len(h.PostDown) > 0 {
+		return fmt.Errorf("sandbox cannot be used together with hooks")
+	}
+	if len(cfg.Connections) > 1 {
+		return fmt.Errorf("sandbox cannot be used with multiple connections")
+	}
+	return nil
+}
+
+// validateDNSZones verifies the zone names and that the zones can be served by
+// the local DNS proxy
+func validateDNSZones(cfg *Config, zones map[string]DNSUpstream) error {
+	if len(zones) == 0 {
+		return nil
+	}
+	if cfg.NetConfig == NetConfigVPNCScript {
+		return fmt.Errorf("dnsZones cannot be used together with vpnc-script")
+	}
+	if cfg.Netns != "" {
+		return fmt.Errorf("dnsZones cannot be used together with a network namespace")
+	}
+	for zone := range zones {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return fmt.Errorf("invalid %q DNS zone", zone)
+		}
+	}
+	return nil
+}
+
+func validateConnections(cfg *Config) error {
+	if len(cfg.Connections) > 1 {
+		if cfg.Netns != "" {
+			return fmt.Errorf("network namespace cannot be used with multiple connections")
+		}
+		if cfg.PolicyRouting.Table != 0 {
+			return fmt.Errorf("policy routing cannot be used with multiple connections")
+		}
+		// the connections update the search suffixes of the shared
+		// resolv.conf, a second rename would overwrite the original backup
+		if !cfg.RewriteResolv {
+			log.Printf("Overriding rewriteResolv: false, multiple connections rewrite /etc/resolv.conf")
+			cfg.RewriteResolv = true
+		}
+	}
+
+	names := make(map[string]bool)
+	tunNames := make(map[string]bool)
+	for _, v := range cfg.Connections {
+		if v.Name == "" {
+			return fmt.Errorf("connection name cannot be empty")
+		}
+		if strings.ContainsRune(v.Name, filepath.Separator) {
+			return fmt.Errorf("invalid %q connection name", v.Name)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate %q connection name", v.Name)
+		}
+		names[v.Name] = true
+		if v.Server == "" {
+			return fmt.Errorf("%q connection server cannot be empty", v.Name)
+		}
+		if v.ProfileIndex < 0 {
+			return fmt.Errorf("%q connection profileIndex cannot be negative", v.Name)
+		}
+		for _, p := range v.Pins {
+			if _, err := pin.Parse(p); err != nil {
+				return fmt.Errorf("%q connection: %s", v.Name, err)
+			}
+		}
+		if v.TunName != "" {
+			if cfg.Driver != "wireguard" {
+				return fmt.Errorf("%q connection tunName requires the wireguard driver", v.Name)
+			}
+			if tunNames[v.TunName] {
+				return fmt.Errorf("duplicate %q connection tunName", v.TunName)
+			}
+			tunNames[v.TunName] = true
+		}
+		if err := validateDNSZones(cfg, v.DNSZones); err != nil {
+			return fmt.Errorf("%q connection: %s", v.Name, err)
+		}
+		// a single DNS proxy dispatches the zones to the corresponding
+		// connection DNS servers
+		if len(cfg.Connections) > 1 && !cfg.DisableDNS && len(v.DNS)+len(v.DNSZones) == 0 {
+			return fmt.Errorf("%q connection requires dns or dnsZones, when multiple connections are used", v.Name)
+		}
+	}
+
+	if cfg.TunName != "" && cfg.Driver != "wireguard" {
+		return fmt.Errorf("tunName requires the wireguard driver")
+	}
+
+	return nil
+}
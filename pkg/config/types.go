@@ -1,17 +1,23 @@
 package config
 
 import (
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/kayrus/gof5/pkg/pin"
+	"github.com/kayrus/gof5/pkg/privsep"
 	"github.com/kayrus/gof5/pkg/util"
 
 	"github.com/IBM/netaddr"
+	"github.com/miekg/dns"
 )
 
 type Config struct {
@@ -28,13 +34,73 @@ type Config struct {
 	IPv6              bool           `yaml:"ipv6"`
 	// completely disable DNS servers handling
 	DisableDNS bool `yaml:"disableDNS"`
+	// DNS zones, resolved by the dedicated DNS servers
+	DNSZones map[string]DNSUpstream `yaml:"dnsZones"`
+	// don't forward the reverse DNS zones of the VPN routes to the VPN DNS
+	// servers
+	DisableReverseDNS bool `yaml:"disableReverseDNS"`
+	// don't apply the host entries, pushed by the server
+	DisableHosts bool `yaml:"disableHosts"`
+	// DNS proxy query log
+	DNSQueryLog DNSQueryLog `yaml:"dnsQueryLog"`
+	// DNS proxy AAAA filtering mode: vpn or all, disabled when empty
+	FilterAAAA string `yaml:"filterAAAA"`
 	// rewrite /etc/resolv.conf instead of renaming
 	// required in ChromeOS, where /etc/resolv.conf cannot be renamed
 	RewriteResolv bool `yaml:"rewriteResolv"`
 	// tls regeneration, tls.RenegotiateNever by default
 	Renegotiation string `yaml:"renegotiation"`
+	// Linux only, put VPN routes into a dedicated routing table
+	PolicyRouting PolicyRouting `yaml:"policyRouting"`
+	// Linux only, move the interface into a named network namespace
+	Netns string `yaml:"netns"`
+	// network configuration backend: builtin or vpnc-script
+	NetConfig string `yaml:"netconfig"`
+	// vpnc-script path, used by the vpnc-script backend
+	VPNCScript string `yaml:"vpncScript"`
+	// custom TUN interface name, wireguard driver only
+	TunName string `yaml:"tunName"`
+	// server addresses, which override the server DNS resolution
+	ServerIPs []net.IP `yaml:"-"`
+	// log the link statistics summary with the interval, disabled when 0
+	StatsInterval time.Duration `yaml:"statsInterval"`
+	// Prometheus metrics listen address, disabled when empty
+	MetricsListen string `yaml:"metricsListen"`
+	// commands, which are executed when the tunnel goes up and down
+	Hooks Hooks `yaml:"hooks"`
+	// Linux only, restrict the syscalls and the filesystem access, when the
+	// tunnel is established
+	Sandbox bool `yaml:"sandbox"`
+	// privilege separation helper settings
+	Privsep Privsep `yaml:"privsep"`
+	// custom TLS parameters
+	TLS TLS `yaml:"tls"`
+	// SPKI SHA-256 pins of the server or its CA public keys
+	Pins []string `yaml:"pins"`
+	// trust the server public key on first use and verify it later
+	TOFU bool `yaml:"tofu"`
+	// server public key verifier, used in addition to the CA verification
+	PinVerifier *pin.Verifier `yaml:"-"`
+	// upstream HTTP or SOCKS5 proxy URL, HTTPS_PROXY is used when empty
+	Proxy string `yaml:"proxy"`
+	// proxy URL, which is used to connect to the server
+	ProxyURL *url.URL `yaml:"-"`
+	// list of simultaneous VPN connections
+	Connections []Connection `yaml:"connections"`
+	// connection name, when multiple connections are used
+	Name string `yaml:"-"`
+	// pcapng file to capture tunnel packets into
+	Pcap string `yaml:"-"`
+	// rotate the pcapng file after reaching the size in bytes
+	PcapMaxSize int64 `yaml:"-"`
+	// amount of rotated pcapng files to keep
+	PcapMaxFiles int `yaml:"-"`
 	// list of detected local DNS servers
 	DNSServers []net.IP `yaml:"-"`
+	// reverse DNS zones of the VPN routes
+	ReverseZones []string `yaml:"-"`
+	// the VPN routes contain the default route
+	FullTunnel bool `yaml:"-"`
 	// config path
 	Path string `yaml:"-"`
 	// current user or sudo user UID
@@ -43,6 +109,231 @@ type Config struct {
 	Gid int `yaml:"-"`
 	// Config, returned by F5
 	F5Config *Favorite `yaml:"-"`
+	// privileged helper, which configures the network, when privilege
+	// separation is used
+	Helper *privsep.Client `yaml:"-"`
+}
+
+// PolicyRouting defines the Linux policy routing mode, when VPN routes are
+// added into a dedicated routing table selected by "ip rule"
+type PolicyRouting struct {
+	// routing table ID, policy routing is disabled when 0
+	Table int `yaml:"table"`
+	// firewall mark set on the gof5 TLS/DTLS socket, defaults to the table ID
+	FwMark int `yaml:"fwmark"`
+	// "ip rule" priority, defaults to 32765, i.e. right before the main table
+	Priority int `yaml:"priority"`
+	// consult the main table routes, except the default route, before the
+	// VPN table, i.e. LAN routes win over the overlapping VPN routes
+	PreferLAN bool `yaml:"preferLAN"`
+}
+
+// Privsep defines the local limits of the privileged helper, which cannot be
+// changed by the unprivileged process
+type Privsep struct {
+	// networks, which may be pushed by the server, required
+	AllowedRoutes *netaddr.IPSet `yaml:"-"`
+	// DNS servers, which may be pushed by the server, no DNS server is
+	// allowed when empty
+	AllowedDNS *netaddr.IPSet `yaml:"-"`
+	// DNS suffixes and their subdomains, which may be pushed by the server,
+	// no DNS suffix is allowed when empty
+	AllowedSuffixes []string `yaml:"allowedSuffixes"`
+}
+
+func (r *Privsep) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s struct {
+		AllowedRoutes   []string `yaml:"allowedRoutes"`
+		AllowedDNS      []string `yaml:"allowedDNS"`
+		AllowedSuffixes []string `yaml:"allowedSuffixes"`
+	}
+
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	if s.AllowedRoutes != nil {
+		parsedCIDRs, err := parseCIDRs(s.AllowedRoutes, net.IPv4len)
+		if err != nil {
+			return err
+		}
+		r.AllowedRoutes = subnetsToIPSet(parsedCIDRs)
+	}
+
+	if s.AllowedDNS != nil {
+		parsedCIDRs, err := parseCIDRs(s.AllowedDNS, net.IPv4len)
+		if err != nil {
+			return err
+		}
+		r.AllowedDNS = subnetsToIPSet(parsedCIDRs)
+	}
+
+	for _, v := range s.AllowedSuffixes {
+		if _, ok := dns.IsDomainName(v); !ok {
+			return fmt.Errorf("invalid %q DNS suffix", v)
+		}
+	}
+	r.AllowedSuffixes = s.AllowedSuffixes
+
+	return nil
+}
+
+// network configuration backends
+const (
+	NetConfigBuiltin    = "builtin"
+	NetConfigVPNCScript = "vpnc-script"
+)
+
+// hooks failure policies
+const (
+	HooksAbort  = "abort"
+	HooksIgnore = "ignore"
+)
+
+// Hooks defines shell commands, which are executed when the tunnel goes up and
+// down
+type Hooks struct {
+	// before the DNS and routes are configured
+	PreUp []string `yaml:"preUp"`
+	// after the connection is established
+	PostUp []string `yaml:"postUp"`
+	// before the DNS and routes are restored
+	PreDown []string `yaml:"preDown"`
+	// after the tunnel is closed
+	PostDown []string `yaml:"postDown"`
+	// a single command timeout, 30s by default
+	Timeout time.Duration `yaml:"timeout"`
+	// "ignore" or "abort", abort terminates the tunnel, when a preUp or a
+	// postUp command fails, "abort" by default
+	OnFailure string `yaml:"onFailure"`
+}
+
+// TLS defines custom TLS parameters, which are used by the HTTPS and DTLS
+// connections
+type TLS struct {
+	// minimal and maximal TLS versions, e.g. "1.2"
+	MinVersion string `yaml:"minVersion"`
+	MaxVersion string `yaml:"maxVersion"`
+	// TLS 1.0-1.2 cipher suite names, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+	CipherSuites []string `yaml:"cipherSuites"`
+	// elliptic curves in the order of preference, e.g. "X25519"
+	Curves []string `yaml:"curves"`
+	// SNI and the certificate name, used when the gateway is reached by IP
+	ServerName string `yaml:"serverName"`
+	// parsed values
+	MinVersionID   uint16        `yaml:"-"`
+	MaxVersionID   uint16        `yaml:"-"`
+	CipherSuiteIDs []uint16      `yaml:"-"`
+	CurveIDs       []tls.CurveID `yaml:"-"`
+	// TLS key log destination, NSS key log format
+	KeyLog io.Writer `yaml:"-"`
+}
+
+// DNSQueryLog defines the DNS proxy query log
+type DNSQueryLog struct {
+	// log the queries from the start, the log can be toggled at runtime
+	Enabled bool `yaml:"enabled"`
+	// JSON lines file, the queries are logged by the logger when empty
+	File string `yaml:"file"`
+}
+
+// DNS proxy AAAA filtering modes
+const (
+	// strip the AAAA records from the VPN DNS answers, when the tunnel has
+	// no IPv6
+	FilterAAAAVPN = "vpn"
+	// answer all AAAA queries with NODATA
+	FilterAAAAAll = "all"
+)
+
+// DNS zone upstream keywords
+const (
+	// the connection DNS servers
+	DNSUpstreamVPN = "vpn"
+	// the original DNS servers
+	DNSUpstreamLocal = "local"
+)
+
+// DNSUpstream defines the DNS zone destination: the DNSUpstreamVPN or
+// DNSUpstreamLocal keyword or a list of DNS servers
+type DNSUpstream struct {
+	Keyword string
+	Servers []net.IP
+}
+
+func (r *DNSUpstream) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var servers []string
+	if err := unmarshal(&servers); err != nil {
+		var s string
+		if err := unmarshal(&s); err != nil {
+			return err
+		}
+		switch s {
+		case DNSUpstreamVPN, DNSUpstreamLocal:
+			r.Keyword = s
+			return nil
+		}
+		servers = []string{s}
+	}
+
+	if len(servers) == 0 {
+		return fmt.Errorf("DNS zone servers cannot be empty")
+	}
+
+	var err error
+	r.Servers, err = parseIPs(servers)
+
+	return err
+}
+
+// Connection defines one of the simultaneous VPN connections
+type Connection struct {
+	Name         string `yaml:"name"`
+	Server       string `yaml:"server"`
+	Username     string `yaml:"username"`
+	ProfileIndex int    `yaml:"profileIndex"`
+	ProfileName  string `yaml:"profileName"`
+	// custom TUN interface name, wireguard driver only
+	TunName string `yaml:"tunName"`
+	// DNS zones, resolved by the connection DNS servers
+	DNS    []string       `yaml:"dns"`
+	Routes *netaddr.IPSet `yaml:"-"`
+	// DNS zones, resolved by the dedicated DNS servers, the top level zones
+	// are used when empty
+	DNSZones map[string]DNSUpstream `yaml:"dnsZones"`
+	// server addresses, which override the server DNS resolution
+	ServerIPs []net.IP `yaml:"-"`
+	// SPKI SHA-256 pins of the server or its CA public keys
+	Pins []string `yaml:"pins"`
+}
+
+func (r *Connection) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type tmp Connection
+	var s struct {
+		tmp       `yaml:",inline"`
+		Routes    []string `yaml:"routes"`
+		ServerIPs []string `yaml:"serverIPs"`
+	}
+
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	*r = Connection(s.tmp)
+
+	if s.Routes != nil {
+		// handle the case, when routes is an empty list
+		parsedCIDRs, err := parseCIDRs(s.Routes, net.IPv4len)
+		if err != nil {
+			return err
+		}
+		r.Routes = subnetsToIPSet(parsedCIDRs)
+	}
+
+	var err error
+	r.ServerIPs, err = parseIPs(s.ServerIPs)
+
+	return err
 }
 
 func (r *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -53,6 +344,7 @@ func (r *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		Routes      []string `yaml:"routes"`
 		PPPdArgs    []string `yaml:"pppdArgs"`
 		OverrideDNS []string `yaml:"overrideDNS"`
+		ServerIPs   []string `yaml:"serverIPs"`
 	}
 
 	if err := unmarshal(&s.tmp); err != nil {
@@ -83,6 +375,12 @@ func (r *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		r.OverrideDNS = processIPs(strings.Join(s.OverrideDNS, " "), net.IPv4len)
 	}
 
+	var err error
+	r.ServerIPs, err = parseIPs(s.ServerIPs)
+	if err != nil {
+		return err
+	}
+
 	// default pppd arguments
 	r.PPPdArgs = []string{
 		"logfd", "2",
@@ -102,6 +400,8 @@ func (r *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		"nopredictor1",
 		"nodeflate", // Protocol-Reject for 'Compression Control Protocol' (0x80fd) received
 		"nobsdcomp", // Protocol-Reject for 'Compression Control Protocol' (0x80fd) received
+		// the LCP echo replies prove the link liveness
+		"lcp-echo-interval", "30",
 	}
 	if len(s.PPPdArgs) > 0 {
 		// extra pppd args
@@ -170,6 +470,13 @@ type Object struct {
 	DNSSuffix                      []string       `xml:"-"`
 	LAN                            []net.IP       `xml:"-"`
 	LAN6                           []net.IP       `xml:"-"`
+	Hosts                          []Host         `xml:"-"`
+}
+
+// Host is a static host entry, pushed by the server
+type Host struct {
+	IP    net.IP
+	Names []string
 }
 
 type TrafficControl struct {
@@ -249,9 +556,40 @@ func (o *Object) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 		o.DNSSuffix = strings.Split(v, ",")
 	}
 
+	o.Hosts = parseHosts(o.Add2Hosts)
+
 	return nil
 }
 
+// parseHosts parses the "ip name [name...]" host entries, separated by a comma,
+// a semicolon or a new line
+func parseHosts(s string) []Host {
+	var hosts []Host
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r'
+	})
+	for _, entry := range entries {
+		var h Host
+		for _, v := range strings.Fields(entry) {
+			if ip := net.ParseIP(v); ip != nil && h.IP == nil {
+				h.IP = ip
+			} else if _, ok := dns.IsDomainName(v); ok && !strings.HasPrefix(v, "#") {
+				h.Names = append(h.Names, v)
+			} else {
+				log.Printf("Ignoring invalid %q Add2Hosts name", v)
+			}
+		}
+		if h.IP == nil || len(h.Names) == 0 {
+			if v := strings.TrimSpace(entry); v != "" {
+				log.Printf("Ignoring invalid %q Add2Hosts entry", v)
+			}
+			continue
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
 type Session struct {
 	Token         string `xml:"token"`
 	Version       string `xml:"version"`
@@ -297,6 +635,18 @@ func processIPs(ips string, length int) []net.IP {
 	return nil
 }
 
+func parseIPs(ips []string) ([]net.IP, error) {
+	var t []net.IP
+	for _, v := range ips {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("failed to parse %q IP address", v)
+		}
+		t = append(t, ip)
+	}
+	return t, nil
+}
+
 func parseCIDRs(cidrs []string, length int) ([]*net.IPNet, error) {
 	t := make([]*net.IPNet, len(cidrs))
 	for i, v := range cidrs {
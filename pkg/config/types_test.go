@@ -0,0 +1,33 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	hosts := parseHosts("10.0.0.1 files.corp files, 2001:db8::1\twiki.corp;bad\n10.0.0.2 ok.corp #comment\r\n10.0.0.3 in$valid..name")
+	expected := []Host{
+		{IP: net.ParseIP("10.0.0.1"), Names: []string{"files.corp", "files"}},
+		{IP: net.ParseIP("2001:db8::1"), Names: []string{"wiki.corp"}},
+		{IP: net.ParseIP("10.0.0.2"), Names: []string{"ok.corp"}},
+	}
+
+	if len(hosts) != len(expected) {
+		t.Fatalf("expected %d host entries, got %v", len(expected), hosts)
+	}
+	for i, v := range expected {
+		if !hosts[i].IP.Equal(v.IP) {
+			t.Errorf("%d: expected %s, got %s", i, v.IP, hosts[i].IP)
+		}
+		if len(hosts[i].Names) != len(v.Names) {
+			t.Errorf("%d: expected %q, got %q", i, v.Names, hosts[i].Names)
+			continue
+		}
+		for j := range v.Names {
+			if hosts[i].Names[j] != v.Names[j] {
+				t.Errorf("%d: expected %q, got %q", i, v.Names, hosts[i].Names)
+			}
+		}
+	}
+}
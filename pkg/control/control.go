@@ -0,0 +1,300 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/dns"
+	"github.com/kayrus/gof5/pkg/link"
+)
+
+// SocketName is the control socket file name in the config directory
+const SocketName = "gof5.sock"
+
+// supported commands
+const (
+	CommandStatus     = "status"
+	CommandReconnect  = "reconnect"
+	CommandDisconnect = "disconnect"
+	// toggles the DNS proxy query log
+	CommandDNSLog = "dns-log"
+)
+
+// connection states
+const (
+	StateConnecting = "connecting"
+	StateConnected  = "connected"
+	StateFailed     = "failed"
+)
+
+const timeout = 10 * time.Second
+
+// Request is a control API request
+type Request struct {
+	Command string `json:"command"`
+	// connection name, all connections when empty
+	Name string `json:"name,omitempty"`
+	// dns-log state
+	Enable bool `json:"enable,omitempty"`
+}
+
+// Response is a control API response
+type Response struct {
+	Connections []Status `json:"connections,omitempty"`
+	// DNS proxy query log state and per zone query counters
+	DNSQueryLog bool            `json:"dnsQueryLog"`
+	DNSZones    []dns.ZoneStats `json:"dnsZones,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// Status is a VPN connection status
+type Status struct {
+	Name       string   `json:"name,omitempty"`
+	Server     string   `json:"server"`
+	State      string   `json:"state"`
+	Interface  string   `json:"interface,omitempty"`
+	LocalIPv4  net.IP   `json:"localIPv4,omitempty"`
+	ServerIPv4 net.IP   `json:"serverIPv4,omitempty"`
+	LocalIPv6  net.IP   `json:"localIPv6,omitempty"`
+	ServerIPv6 net.IP   `json:"serverIPv6,omitempty"`
+	Routes     []string `json:"routes,omitempty"`
+	// VPN DNS servers and search suffixes
+	DNS         []net.IP `json:"dns,omitempty"`
+	DNSSuffixes []string `json:"dnsSuffixes,omitempty"`
+	// DNS zones, forwarded to the VPN DNS servers
+	DNSZones []string `json:"dnsZones,omitempty"`
+	// the connection failure, when the state is failed
+	Error string `json:"error,omitempty"`
+	// the VPN session start time
+	SessionStart time.Time `json:"sessionStart"`
+	// reconnects by reason
+	Reconnects map[string]uint64 `json:"reconnects,omitempty"`
+	// login failures by error type
+	LoginFailures map[string]uint64 `json:"loginFailures,omitempty"`
+	Stats         *link.Stats       `json:"stats,omitempty"`
+}
+
+// Connection is a VPN connection, controlled through the socket
+type Connection interface {
+	Status() Status
+	Reconnect()
+	Disconnect()
+}
+
+// Server serves the control API on a Unix domain socket
+type Server struct {
+	sync.Mutex
+	path        string
+	listener    net.Listener
+	connections map[string]Connection
+}
+
+// New returns a new connections registry, which is served by the control API
+func New() *Server {
+	return &Server{
+		connections: make(map[string]Connection),
+	}
+}
+
+// Listen creates the control socket, which is accessible only by the uid/gid
+// user
+func (s *Server) Listen(path string, uid, gid int) error {
+	if conn, err := net.DialTimeout("unix", path, timeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("%q control socket is used by another gof5 process", path)
+	}
+	// remove the stale socket
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale %q control socket: %s", path, err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q control socket: %s", path, err)
+	}
+
+	// the config directory is accessible only by the user, the socket
+	// permissions are additionally restricted
+	if err = os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return fmt.Errorf("failed to set %q control socket permissions: %s", path, err)
+	}
+
+	// windows preserves the original user parameters, no need to chown
+	if runtime.GOOS != "windows" {
+		if err = os.Chown(path, uid, gid); err != nil {
+			l.Close()
+			return fmt.Errorf("failed to set an owner for the %q control socket: %s", path, err)
+		}
+	}
+
+	s.path = path
+	s.listener = l
+	go s.serve()
+
+	return nil
+}
+
+// Register adds the connection to the control API
+func (s *Server) Register(name string, c Connection) {
+	s.Lock()
+	defer s.Unlock()
+	s.connections[name] = c
+}
+
+// Unregister removes the connection from the control API
+func (s *Server) Unregister(name string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.connections, name)
+}
+
+// Connections returns all registered connections
+func (s *Server) Connections() []Connection {
+	_, conns := s.lookup("")
+	return conns
+}
+
+// Statuses returns the statuses of all registered connections
+func (s *Server) Statuses() []Status {
+	_, conns := s.lookup("")
+	res := make([]Status, len(conns))
+	for i, c := range conns {
+		res[i] = c.Status()
+	}
+	return res
+}
+
+// Close stops the control API and removes the socket
+func (s *Server) Close() {
+	if s.listener == nil {
+		return
+	}
+	s.listener.Close()
+	os.Remove(s.path)
+}
+
+// lookup returns the connections, matching the name, all connections when
+// the name is empty
+func (s *Server) lookup(name string) ([]string, []Connection) {
+	s.Lock()
+	defer s.Unlock()
+
+	var names []string
+	for v := range s.connections {
+		if name == "" || name == v {
+			names = append(names, v)
+		}
+	}
+	sort.Strings(names)
+	conns := make([]Connection, len(names))
+	for i, v := range names {
+		conns[i] = s.connections[v]
+	}
+
+	return names, conns
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Failed to accept control connection: %s", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var req Request
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&req); err == io.EOF {
+		// the connection is closed without a request, e.g. a liveness check
+		return
+	} else if err != nil {
+		resp.Error = fmt.Sprintf("failed to decode request: %s", err)
+	} else {
+		resp = s.process(req)
+	}
+
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		log.Printf("Failed to send control response: %s", err)
+	}
+}
+
+func (s *Server) process(req Request) Response {
+	names, conns := s.lookup(req.Name)
+
+	if len(conns) == 0 {
+		if req.Name != "" {
+			return Response{Error: fmt.Sprintf("%q connection not found", req.Name)}
+		}
+		return Response{Error: "no connections"}
+	}
+
+	var resp Response
+	switch req.Command {
+	case CommandStatus:
+	case CommandReconnect:
+		log.Printf("Reconnect of %q requested through the control socket", names)
+		for _, c := range conns {
+			c.Reconnect()
+		}
+	case CommandDisconnect:
+		log.Printf("Disconnect of %q requested through the control socket", names)
+		for _, c := range conns {
+			c.Disconnect()
+		}
+	case CommandDNSLog:
+		dns.SetQueryLog(req.Enable)
+	default:
+		return Response{Error: fmt.Sprintf("unsupported %q command", req.Command)}
+	}
+
+	for _, c := range conns {
+		resp.Connections = append(resp.Connections, c.Status())
+	}
+	resp.DNSQueryLog = dns.QueryLogEnabled()
+	resp.DNSZones = dns.Zones()
+
+	return resp
+}
+
+// Send sends the request to the control socket and returns the response
+func Send(path string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %q control socket, is gof5 running? %s", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err = json.NewEncoder(conn).Encode(&req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %s", err)
+	}
+
+	var resp Response
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %s", err)
+	}
+	if resp.Error != "" {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,95 @@
+package control
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeConnection struct {
+	status      Status
+	reconnects  int
+	disconnects int
+}
+
+func (c *fakeConnection) Status() Status {
+	return c.status
+}
+
+func (c *fakeConnection) Reconnect() {
+	c.reconnects++
+}
+
+func (c *fakeConnection) Disconnect() {
+	c.disconnects++
+}
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SocketName)
+	s := New()
+	if err := s.Listen(path, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := New().Listen(path, os.Getuid(), os.Getgid()); err == nil {
+		t.Errorf("expected the socket to be used by another server")
+	}
+
+	a := &fakeConnection{status: Status{Name: "a", State: StateConnected}}
+	b := &fakeConnection{status: Status{Name: "b", State: StateConnecting}}
+	s.Register("b", b)
+	s.Register("a", a)
+
+	resp, err := Send(path, Request{Command: CommandStatus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Connections) != 2 || resp.Connections[0].Name != "a" || resp.Connections[1].State != StateConnecting {
+		t.Errorf("unexpected connections: %+v", resp.Connections)
+	}
+
+	if _, err = Send(path, Request{Command: CommandReconnect, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Send(path, Request{Command: CommandDisconnect}); err != nil {
+		t.Fatal(err)
+	}
+	if a.reconnects != 0 || b.reconnects != 1 || a.disconnects != 1 || b.disconnects != 1 {
+		t.Errorf("unexpected commands: %+v, %+v", a, b)
+	}
+
+	for _, req := range []Request{
+		{Command: CommandStatus, Name: "c"},
+		{Command: "restart"},
+	} {
+		if _, err = Send(path, req); err == nil {
+			t.Errorf("%+v: expected an error", req)
+		}
+	}
+
+	// a malformed request gets an error response
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("status\n")); err != nil {
+		t.Fatal(err)
+	}
+	var raw Response
+	if err = json.NewDecoder(conn).Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Error == "" {
+		t.Errorf("expected an error response")
+	}
+
+	s.Unregister("a")
+	s.Unregister("b")
+	if _, err = Send(path, Request{Command: CommandStatus}); err == nil {
+		t.Errorf("expected no connections error")
+	}
+}
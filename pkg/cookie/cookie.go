@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/kayrus/gof5/pkg/config"
@@ -26,6 +27,9 @@ import (
 const cookiesName = "cookies.yaml"
 const cookieEncPrefix = "ENCv1:"
 
+// mu protects the cookies file, shared between simultaneous connections
+var mu sync.Mutex
+
 func parseCookies(configPath string, key []byte) (map[string][]string, bool, error) {
 	cookies := make(map[string][]string)
 
@@ -61,6 +65,9 @@ func parseCookies(configPath string, key []byte) (map[string][]string, bool, err
 }
 
 func ReadCookies(c *http.Client, u *url.URL, cfg *config.Config, sessionID string, key []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	v, encrypted, err := parseCookies(cfg.Path, key)
 	if err != nil {
 		return err
@@ -90,6 +97,9 @@ func ReadCookies(c *http.Client, u *url.URL, cfg *config.Config, sessionID strin
 }
 
 func SaveCookies(c *http.Client, u *url.URL, cfg *config.Config, key []byte, allowPlaintext bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	raw, _, err := parseCookies(cfg.Path, key)
 	if err != nil {
 		return err
@@ -0,0 +1,252 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// supported input formats
+const (
+	FormatAuto = "auto"
+	FormatRaw  = "raw"
+	FormatHex  = "hex"
+	FormatPcap = "pcap"
+)
+
+// Formats is a list of supported input formats
+var Formats = []string{FormatAuto, FormatRaw, FormatHex, FormatPcap}
+
+var (
+	f5Header  = []byte{0xf5, 0x00}
+	hdlcFlag  = byte(0x7e)
+	hdlcEsc   = byte(0x7d)
+	f5HdrSize = 4
+)
+
+// Decode reads a raw F5 byte stream, a gof5 debug log with hex dumps or a
+// pcap/pcapng capture and prints decoded F5 frames, HDLC frames, PPP control
+// messages and IP headers
+func Decode(r io.Reader, w io.Writer, format string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %s", err)
+	}
+
+	if format == FormatAuto {
+		format = detectFormat(data)
+	}
+
+	d := &decoder{w: w}
+	switch format {
+	case FormatRaw:
+		s := &stream{}
+		d.printf(0, "raw stream, %d bytes\n", len(data))
+		d.feed(1, s, data)
+		d.flush(1, s)
+		return nil
+	case FormatHex:
+		return d.hexDump(data)
+	case FormatPcap:
+		return d.pcap(data)
+	}
+
+	return fmt.Errorf("unsupported %q input format", format)
+}
+
+func detectFormat(data []byte) string {
+	if len(data) >= 4 {
+		switch binary.LittleEndian.Uint32(data) {
+		case pcapMagic, pcapMagicNano, pcapMagicSwapped, pcapMagicNanoSwapped, blockSHB:
+			return FormatPcap
+		}
+	}
+	if hexDumpLine.Match(data) {
+		return FormatHex
+	}
+	return FormatRaw
+}
+
+type decoder struct {
+	w io.Writer
+}
+
+func (d *decoder) printf(indent int, format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "%*s"+format, append([]interface{}{indent * 2, ""}, args...)...)
+}
+
+// stream kinds
+const (
+	streamUnknown = iota
+	// F5 framed PPP frames
+	streamF5
+	// HDLC-like framed PPP frames, used by the pppd driver
+	streamHDLC
+	// each chunk is a single PPP frame or an IP packet
+	streamPackets
+)
+
+// stream holds a partially received framed stream
+type stream struct {
+	kind int
+	buf  []byte
+}
+
+// isStream reports whether the data looks like the beginning of a framed
+// stream
+func isStream(data []byte) bool {
+	return bytes.HasPrefix(data, f5Header) || len(data) > 0 && data[0] == hdlcFlag
+}
+
+// feed appends the data to the stream and prints all complete frames
+func (d *decoder) feed(indent int, s *stream, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	if s.kind == streamUnknown {
+		switch {
+		case bytes.HasPrefix(data, f5Header):
+			s.kind = streamF5
+		case data[0] == hdlcFlag:
+			s.kind = streamHDLC
+		default:
+			s.kind = streamPackets
+		}
+	}
+
+	switch s.kind {
+	case streamF5:
+		s.buf = append(s.buf, data...)
+		d.splitF5(indent, s)
+	case streamHDLC:
+		s.buf = append(s.buf, data...)
+		d.splitHDLC(indent, s)
+	default:
+		d.packet(indent, data)
+	}
+}
+
+// flush reports the incomplete data left in the stream
+func (d *decoder) flush(indent int, s *stream) {
+	if s.kind == streamHDLC && len(bytes.Trim(s.buf, string(hdlcFlag))) == 0 {
+		// the closing flag
+		s.buf = nil
+	}
+	if len(s.buf) == 0 {
+		return
+	}
+	if s.kind == streamF5 && len(s.buf) >= f5HdrSize && bytes.HasPrefix(s.buf, f5Header) {
+		d.printf(indent, "truncated F5 frame: %d of %d bytes\n", len(s.buf)-f5HdrSize, binary.BigEndian.Uint16(s.buf[2:]))
+	} else {
+		d.printf(indent, "%d trailing bytes: %x\n", len(s.buf), s.buf)
+	}
+	s.buf = nil
+}
+
+func (d *decoder) splitF5(indent int, s *stream) {
+	for len(s.buf) > 0 {
+		if !bytes.HasPrefix(s.buf, f5Header) {
+			if len(s.buf) < len(f5Header) && bytes.HasPrefix(f5Header, s.buf) {
+				return
+			}
+			// resync on the next F5 header
+			n := bytes.Index(s.buf, f5Header)
+			if n < 0 {
+				n = len(s.buf)
+			}
+			d.printf(indent, "skipped %d bytes without F5 header: %x\n", n, s.buf[:n])
+			s.buf = s.buf[n:]
+			continue
+		}
+		if len(s.buf) < f5HdrSize {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(s.buf[2:]))
+		if len(s.buf) < f5HdrSize+size {
+			return
+		}
+		d.printf(indent, "F5 frame, %d bytes\n", size)
+		d.ppp(indent+1, s.buf[f5HdrSize:f5HdrSize+size])
+		s.buf = s.buf[f5HdrSize+size:]
+	}
+}
+
+func (d *decoder) splitHDLC(indent int, s *stream) {
+	for {
+		start := bytes.IndexByte(s.buf, hdlcFlag)
+		if start < 0 {
+			if len(s.buf) > 0 {
+				d.printf(indent, "skipped %d bytes without HDLC flag: %x\n", len(s.buf), s.buf)
+			}
+			s.buf = s.buf[:0]
+			return
+		}
+		if start > 0 {
+			d.printf(indent, "skipped %d bytes without HDLC flag: %x\n", start, s.buf[:start])
+			s.buf = s.buf[start:]
+		}
+		end := bytes.IndexByte(s.buf[1:], hdlcFlag)
+		if end < 0 {
+			return
+		}
+		frame := s.buf[1 : end+1]
+		// the closing flag may open the next frame
+		s.buf = s.buf[end+1:]
+		if len(frame) == 0 {
+			continue
+		}
+		d.hdlc(indent, frame)
+	}
+}
+
+// hdlc prints an HDLC-like frame without flags
+func (d *decoder) hdlc(indent int, frame []byte) {
+	buf := make([]byte, 0, len(frame))
+	for i := 0; i < len(frame); i++ {
+		if frame[i] == hdlcEsc {
+			i++
+			if i == len(frame) {
+				d.printf(indent, "invalid HDLC frame, trailing escape: %x\n", frame)
+				return
+			}
+			buf = append(buf, frame[i]^0x20)
+			continue
+		}
+		buf = append(buf, frame[i])
+	}
+	if len(buf) < 3 {
+		d.printf(indent, "invalid HDLC frame, too short: %x\n", frame)
+		return
+	}
+
+	fcs := "FCS ok"
+	if fcs16(buf) != fcsGood {
+		fcs = "bad FCS"
+	}
+	d.printf(indent, "HDLC frame, %d bytes, %s\n", len(buf)-2, fcs)
+	d.ppp(indent+1, buf[:len(buf)-2])
+}
+
+const (
+	fcsInit = 0xffff
+	fcsGood = 0xf0b8
+)
+
+// fcs16 calculates the PPP frame check sequence, see RFC 1662
+func fcs16(data []byte) uint16 {
+	fcs := uint16(fcsInit)
+	for _, b := range data {
+		fcs ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if fcs&1 == 1 {
+				fcs = fcs>>1 ^ 0x8408
+			} else {
+				fcs >>= 1
+			}
+		}
+	}
+	return fcs
+}
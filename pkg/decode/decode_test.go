@@ -0,0 +1,84 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHexDump(t *testing.T) {
+	frame := []byte{0xf5, 0x00, 0x00, 0x18, 0xff, 0x03, 0xc0, 0x21, 0x01, 0x01, 0x00, 0x14, 0x01, 0x04, 0x05, 0xdc, 0x02, 0x06, 0x00, 0x00, 0x00, 0x00, 0x05, 0x06, 0x12, 0x34, 0x56, 0x78}
+	log := "2021/01/01 00:00:00 Read 28 bytes from http:\n" + hex.Dump(frame) +
+		"2021/01/01 00:00:00 Sending from pppd:\n" + hex.Dump([]byte{0x80, 0x21, 0x02, 0x02, 0x00, 0x0a, 0x03, 0x06, 0x0a, 0x00, 0x00, 0x01})
+
+	out := &bytes.Buffer{}
+	if err := Decode(strings.NewReader(log), out, FormatAuto); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	expected := `block 1, 28 bytes: 2021/01/01 00:00:00 Read 28 bytes from http:
+  F5 frame, 24 bytes
+    PPP LCP Configure-Request id=1
+      MRU: 1500
+      ACCM: 0x00000000
+      Magic-Number: 0x12345678
+block 2, 12 bytes: 2021/01/01 00:00:00 Sending from pppd:
+  PPP IPCP Configure-Ack id=2
+    IP-Address: 10.0.0.1
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestDecodePcapTCPStream(t *testing.T) {
+	// F5 frame with an LCP Echo-Request split between two TCP segments
+	frame := []byte{0xf5, 0x00, 0x00, 0x0c, 0xff, 0x03, 0xc0, 0x21, 0x09, 0x07, 0x00, 0x08, 0x12, 0x34, 0x56, 0x78}
+
+	buf := &bytes.Buffer{}
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr, pcapMagic)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	buf.Write(hdr)
+	seq := uint32(1000)
+	for _, v := range [][]byte{frame[:6], frame[6:]} {
+		pkt := ipv4TCP(seq, v)
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+		buf.Write(rec)
+		buf.Write(pkt)
+		seq += uint32(len(v))
+	}
+
+	out := &bytes.Buffer{}
+	if err := Decode(buf, out, FormatAuto); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	for _, v := range []string{"F5 frame, 12 bytes", "PPP LCP Echo-Request id=7", "Magic-Number: 0x12345678"} {
+		if !strings.Contains(out.String(), v) {
+			t.Errorf("output doesn't contain %q:\n%s", v, out)
+		}
+	}
+	if strings.Contains(out.String(), "trailing") || strings.Contains(out.String(), "truncated") {
+		t.Errorf("unexpected incomplete frame:\n%s", out)
+	}
+}
+
+func ipv4TCP(seq uint32, payload []byte) []byte {
+	pkt := make([]byte, 40, 40+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:], uint16(40+len(payload)))
+	pkt[8] = 64
+	pkt[9] = ipProtoTCP
+	copy(pkt[12:], []byte{192, 0, 2, 1, 192, 0, 2, 2})
+	binary.BigEndian.PutUint16(pkt[20:], 443)
+	binary.BigEndian.PutUint16(pkt[22:], 50000)
+	binary.BigEndian.PutUint32(pkt[24:], seq)
+	pkt[32] = 5 << 4
+	pkt[33] = 0x18
+	return append(pkt, payload...)
+}
@@ -0,0 +1,71 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hexDumpLine matches the encoding/hex Dump output line, which may have a
+// log prefix
+var hexDumpLine = regexp.MustCompile(`(?m)([0-9a-f]{8})  ((?:[0-9a-f]{2} {1,2}){1,16}) *\|`)
+
+// hexBlock is a hex dump with the preceding log line
+type hexBlock struct {
+	title string
+	data  []byte
+}
+
+// parseHexDump returns hex dump blocks, found in a debug log
+func parseHexDump(data []byte) []hexBlock {
+	var blocks []hexBlock
+	var title string
+	var cur *hexBlock
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := hexDumpLine.FindStringSubmatch(line)
+		if m == nil {
+			cur = nil
+			if v := strings.TrimSpace(line); v != "" {
+				title = v
+			}
+			continue
+		}
+
+		offset, _ := strconv.ParseUint(m[1], 16, 32)
+		b, err := hex.DecodeString(strings.Join(strings.Fields(m[2]), ""))
+		if err != nil {
+			continue
+		}
+		// a zero offset or an offset gap starts a new block
+		if cur == nil || offset == 0 || int(offset) != len(cur.data) {
+			blocks = append(blocks, hexBlock{title: title})
+			cur = &blocks[len(blocks)-1]
+			title = ""
+		}
+		cur.data = append(cur.data, b...)
+	}
+
+	return blocks
+}
+
+// hexDump prints all hex dump blocks, each block is decoded separately
+func (d *decoder) hexDump(data []byte) error {
+	for i, b := range parseHexDump(data) {
+		if b.title != "" {
+			d.printf(0, "block %d, %d bytes: %s\n", i+1, len(b.data), b.title)
+		} else {
+			d.printf(0, "block %d, %d bytes\n", i+1, len(b.data))
+		}
+		s := &stream{}
+		d.feed(1, s, b.data)
+		d.flush(1, s)
+	}
+	return nil
+}
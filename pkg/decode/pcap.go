@@ -0,0 +1,501 @@
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// pcap magic numbers, read as little endian
+const (
+	pcapMagic            = 0xa1b2c3d4
+	pcapMagicNano        = 0xa1b23c4d
+	pcapMagicSwapped     = 0xd4c3b2a1
+	pcapMagicNanoSwapped = 0x4d3cb2a1
+)
+
+// pcapng block types
+const (
+	blockSHB = 0x0a0d0d0a
+	blockIDB = 0x00000001
+	blockSPB = 0x00000003
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+	optEpbFlags    = 2
+	optIfTsresol   = 9
+)
+
+// link types, see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypePPP      = 9
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeUpperPDU = 252
+	linkTypeSLL2     = 276
+)
+
+// ethernet types
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+// exported PDU tags, used by the Wireshark "Export PDUs to File" feature
+const (
+	pduTagEnd     = 0
+	pduTagIPv4Src = 20
+	pduTagIPv4Dst = 21
+	pduTagIPv6Src = 22
+	pduTagIPv6Dst = 23
+	pduTagSrcPort = 25
+	pduTagDstPort = 26
+)
+
+// packet is a captured packet
+type packet struct {
+	num      int
+	ts       time.Time
+	linkType uint16
+	// pcapng only: 1 - inbound, 2 - outbound
+	direction uint32
+	data      []byte
+}
+
+// tcpFlow holds a reassembled TCP stream state
+type tcpFlow struct {
+	stream
+	next    uint32
+	seqSeen bool
+}
+
+type pcapDecoder struct {
+	*decoder
+	flows map[string]*tcpFlow
+}
+
+// pcap prints packets from a pcap or pcapng capture
+func (d *decoder) pcap(data []byte) error {
+	p := &pcapDecoder{decoder: d, flows: make(map[string]*tcpFlow)}
+
+	var err error
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == blockSHB {
+		err = readPcapng(data, p.packet)
+	} else {
+		err = readPcap(data, p.packet)
+	}
+
+	keys := make([]string, 0, len(p.flows))
+	for k := range p.flows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := p.flows[k]; len(v.buf) > 0 {
+			d.printf(0, "flow %s\n", k)
+			d.flush(1, &v.stream)
+		}
+	}
+
+	return err
+}
+
+func readPcap(data []byte, fn func(*packet)) error {
+	if len(data) < 24 {
+		return fmt.Errorf("truncated pcap header")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	nano := false
+	switch binary.LittleEndian.Uint32(data) {
+	case pcapMagic:
+	case pcapMagicNano:
+		nano = true
+	case pcapMagicSwapped:
+		order = binary.BigEndian
+	case pcapMagicNanoSwapped:
+		order = binary.BigEndian
+		nano = true
+	default:
+		return fmt.Errorf("invalid pcap magic: %x", data[:4])
+	}
+	linkType := uint16(order.Uint32(data[20:]))
+	data = data[24:]
+
+	for num := 1; len(data) > 0; num++ {
+		if len(data) < 16 {
+			return fmt.Errorf("truncated pcap record header")
+		}
+		sec, frac := order.Uint32(data), order.Uint32(data[4:])
+		size := int(order.Uint32(data[8:]))
+		if len(data) < 16+size {
+			return fmt.Errorf("truncated pcap record")
+		}
+		ts := time.Unix(int64(sec), int64(frac)*int64(time.Microsecond))
+		if nano {
+			ts = time.Unix(int64(sec), int64(frac))
+		}
+		fn(&packet{
+			num:      num,
+			ts:       ts,
+			linkType: linkType,
+			data:     data[16 : 16+size],
+		})
+		data = data[16+size:]
+	}
+
+	return nil
+}
+
+// pcapng interface description
+type pcapngIface struct {
+	linkType uint16
+	// timestamp units per second
+	tsresol uint64
+}
+
+func readPcapng(data []byte, fn func(*packet)) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var ifaces []pcapngIface
+
+	for num := 1; len(data) > 0; {
+		if len(data) < 12 {
+			return fmt.Errorf("truncated pcapng block header")
+		}
+		if binary.LittleEndian.Uint32(data) == blockSHB {
+			// section header defines the byte order
+			switch binary.LittleEndian.Uint32(data[8:]) {
+			case byteOrderMagic:
+				order = binary.LittleEndian
+			default:
+				order = binary.BigEndian
+			}
+			ifaces = nil
+		}
+
+		typ, size := order.Uint32(data), int(order.Uint32(data[4:]))
+		if size < 12 || size%4 != 0 || size > len(data) {
+			return fmt.Errorf("invalid pcapng block size: %d", size)
+		}
+		body := data[8 : size-4]
+		data = data[size:]
+
+		switch typ {
+		case blockIDB:
+			if len(body) < 8 {
+				return fmt.Errorf("truncated pcapng interface description block")
+			}
+			iface := pcapngIface{linkType: order.Uint16(body), tsresol: 1000000}
+			walkOptions(order, body[8:], func(code uint16, value []byte) {
+				if code == optIfTsresol && len(value) == 1 {
+					iface.tsresol = tsresol(value[0])
+				}
+			})
+			ifaces = append(ifaces, iface)
+		case blockEPB:
+			if len(body) < 20 {
+				return fmt.Errorf("truncated pcapng enhanced packet block")
+			}
+			id := int(order.Uint32(body))
+			if id >= len(ifaces) {
+				return fmt.Errorf("unknown pcapng interface %d", id)
+			}
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			capLen := int(order.Uint32(body[12:]))
+			if 20+capLen > len(body) {
+				return fmt.Errorf("truncated pcapng packet data")
+			}
+			p := &packet{
+				num:      num,
+				ts:       tsTime(ts, ifaces[id].tsresol),
+				linkType: ifaces[id].linkType,
+				data:     body[20 : 20+capLen],
+			}
+			walkOptions(order, body[20+capLen+pad(capLen):], func(code uint16, value []byte) {
+				if code == optEpbFlags && len(value) == 4 {
+					p.direction = order.Uint32(value) & 0x3
+				}
+			})
+			fn(p)
+			num++
+		case blockSPB:
+			if len(body) < 4 || len(ifaces) == 0 {
+				return fmt.Errorf("invalid pcapng simple packet block")
+			}
+			capLen := int(order.Uint32(body))
+			if capLen > len(body)-4 {
+				capLen = len(body) - 4
+			}
+			fn(&packet{
+				num:      num,
+				linkType: ifaces[0].linkType,
+				data:     body[4 : 4+capLen],
+			})
+			num++
+		}
+	}
+
+	return nil
+}
+
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+func walkOptions(order binary.ByteOrder, data []byte, fn func(uint16, []byte)) {
+	for len(data) >= 4 {
+		code, size := order.Uint16(data), int(order.Uint16(data[2:]))
+		if code == 0 || 4+size > len(data) {
+			return
+		}
+		fn(code, data[4:4+size])
+		data = data[4+size+pad(size):]
+	}
+}
+
+// tsresol converts the if_tsresol option into units per second
+func tsresol(v byte) uint64 {
+	res := uint64(1)
+	for i := byte(0); i < v&0x7f; i++ {
+		if v&0x80 != 0 {
+			res *= 2
+		} else {
+			res *= 10
+		}
+	}
+	return res
+}
+
+func tsTime(ts, resol uint64) time.Time {
+	if resol == 0 {
+		return time.Time{}
+	}
+	sec := ts / resol
+	nsec := (ts % resol) * uint64(time.Second) / resol
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+var directions = map[uint32]string{
+	1: ", inbound",
+	2: ", outbound",
+}
+
+// packet prints a captured packet
+func (p *pcapDecoder) packet(pkt *packet) {
+	ts := ""
+	if !pkt.ts.IsZero() {
+		ts = ", " + pkt.ts.UTC().Format("2006-01-02T15:04:05.000000Z")
+	}
+	p.printf(0, "packet %d%s%s, %d bytes\n", pkt.num, ts, directions[pkt.direction], len(pkt.data))
+
+	data := pkt.data
+	switch pkt.linkType {
+	case linkTypePPP:
+		p.ppp(1, data)
+		return
+	case linkTypeUpperPDU:
+		p.upperPDU(data)
+		return
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	case linkTypeNull:
+		if len(data) < 4 {
+			p.printf(1, "truncated loopback header\n")
+			return
+		}
+		data = data[4:]
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			p.printf(1, "truncated ethernet header\n")
+			return
+		}
+		etherType, n := binary.BigEndian.Uint16(data[12:]), 14
+		if etherType == etherTypeVLAN && len(data) >= 18 {
+			etherType, n = binary.BigEndian.Uint16(data[16:]), 18
+		}
+		if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+			p.printf(1, "ethernet type 0x%04x\n", etherType)
+			return
+		}
+		data = data[n:]
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			p.printf(1, "truncated Linux cooked header\n")
+			return
+		}
+		data = data[16:]
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			p.printf(1, "truncated Linux cooked v2 header\n")
+			return
+		}
+		data = data[20:]
+	default:
+		p.printf(1, "unsupported link type %d\n", pkt.linkType)
+		return
+	}
+
+	if len(data) == 0 {
+		p.printf(1, "empty packet\n")
+		return
+	}
+	p.ipPacket(data)
+}
+
+// ipPacket prints an IP packet. When the TCP or UDP payload contains an F5 or
+// HDLC stream, e.g. a decrypted TLS session, the stream is decoded.
+func (p *pcapDecoder) ipPacket(data []byte) {
+	src, dst, proto, payload, ok := transport(data)
+	if !ok || proto != ipProtoTCP && proto != ipProtoUDP {
+		p.ip(1, data)
+		return
+	}
+
+	var key string
+	var seq uint32
+	switch proto {
+	case ipProtoTCP:
+		if len(payload) < 20 {
+			p.ip(1, data)
+			return
+		}
+		off := int(payload[12]>>4) * 4
+		if off < 20 || off > len(payload) {
+			p.ip(1, data)
+			return
+		}
+		key = fmt.Sprintf("tcp %s > %s", net.JoinHostPort(src.String(), fmt.Sprint(binary.BigEndian.Uint16(payload))), net.JoinHostPort(dst.String(), fmt.Sprint(binary.BigEndian.Uint16(payload[2:]))))
+		seq = binary.BigEndian.Uint32(payload[4:])
+		payload = payload[off:]
+	case ipProtoUDP:
+		if len(payload) < 8 {
+			p.ip(1, data)
+			return
+		}
+		key = fmt.Sprintf("udp %s > %s", net.JoinHostPort(src.String(), fmt.Sprint(binary.BigEndian.Uint16(payload))), net.JoinHostPort(dst.String(), fmt.Sprint(binary.BigEndian.Uint16(payload[2:]))))
+		payload = payload[8:]
+	}
+
+	if len(payload) == 0 {
+		p.ip(1, data)
+		return
+	}
+
+	f, ok := p.flows[key]
+	if !ok {
+		if !isStream(payload) {
+			p.ip(1, data)
+			return
+		}
+		f = &tcpFlow{}
+		p.flows[key] = f
+	}
+
+	p.printf(1, "%s, %d bytes\n", key, len(payload))
+	if proto == ipProtoUDP {
+		// each datagram contains complete frames
+		p.feed(2, &f.stream, payload)
+		p.flush(2, &f.stream)
+		return
+	}
+
+	if f.seqSeen && seq != f.next {
+		diff := int32(f.next - seq)
+		switch {
+		case diff > 0 && int(diff) >= len(payload):
+			p.printf(2, "retransmission\n")
+			return
+		case diff > 0:
+			payload = payload[diff:]
+			seq = f.next
+		default:
+			p.printf(2, "%d bytes are missing\n", -diff)
+			p.flush(2, &f.stream)
+			f.kind = streamUnknown
+		}
+	}
+	f.seqSeen = true
+	f.next = seq + uint32(len(payload))
+	p.feed(2, &f.stream, payload)
+}
+
+// upperPDU prints a Wireshark exported PDU, e.g. a decrypted TLS payload
+func (p *pcapDecoder) upperPDU(data []byte) {
+	var src, dst net.IP
+	var srcPort, dstPort uint32
+	for {
+		if len(data) < 4 {
+			p.printf(1, "truncated exported PDU header\n")
+			return
+		}
+		tag, size := binary.BigEndian.Uint16(data), int(binary.BigEndian.Uint16(data[2:]))
+		if 4+size > len(data) {
+			p.printf(1, "truncated exported PDU tag\n")
+			return
+		}
+		value := data[4 : 4+size]
+		data = data[4+size:]
+		if tag == pduTagEnd {
+			break
+		}
+		switch tag {
+		case pduTagIPv4Src, pduTagIPv6Src:
+			src = net.IP(value)
+		case pduTagIPv4Dst, pduTagIPv6Dst:
+			dst = net.IP(value)
+		case pduTagSrcPort:
+			if len(value) == 4 {
+				srcPort = binary.BigEndian.Uint32(value)
+			}
+		case pduTagDstPort:
+			if len(value) == 4 {
+				dstPort = binary.BigEndian.Uint32(value)
+			}
+		}
+	}
+
+	key := fmt.Sprintf("pdu %s > %s", net.JoinHostPort(src.String(), fmt.Sprint(srcPort)), net.JoinHostPort(dst.String(), fmt.Sprint(dstPort)))
+	f, ok := p.flows[key]
+	if !ok {
+		f = &tcpFlow{}
+		p.flows[key] = f
+	}
+	p.printf(1, "%s, %d bytes\n", key, len(data))
+	p.feed(2, &f.stream, data)
+}
+
+// transport returns the IP addresses, transport protocol and its payload
+func transport(data []byte) (net.IP, net.IP, int, []byte, bool) {
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return nil, nil, 0, nil, false
+		}
+		hl := int(data[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if hl < 20 || total < hl || total > len(data) {
+			return nil, nil, 0, nil, false
+		}
+		// skip fragments
+		if binary.BigEndian.Uint16(data[6:])&0x3fff != 0 {
+			return nil, nil, 0, nil, false
+		}
+		return net.IP(data[12:16]), net.IP(data[16:20]), int(data[9]), data[hl:total], true
+	case 6:
+		if len(data) < 40 {
+			return nil, nil, 0, nil, false
+		}
+		total := 40 + int(binary.BigEndian.Uint16(data[4:]))
+		if total > len(data) {
+			return nil, nil, 0, nil, false
+		}
+		return net.IP(data[8:24]), net.IP(data[24:40]), int(data[6]), data[40:total], true
+	}
+	return nil, nil, 0, nil, false
+}
@@ -0,0 +1,300 @@
+package decode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// PPP protocol numbers
+const (
+	protoIPv4   = 0x0021
+	protoIPv6   = 0x0057
+	protoIPCP   = 0x8021
+	protoIPv6CP = 0x8057
+	protoCCP    = 0x80fd
+	protoLCP    = 0xc021
+	protoPAP    = 0xc023
+	protoCHAP   = 0xc223
+)
+
+var protoNames = map[uint16]string{
+	protoIPv4:   "IPv4",
+	protoIPv6:   "IPv6",
+	protoIPCP:   "IPCP",
+	protoIPv6CP: "IPV6CP",
+	protoCCP:    "CCP",
+	protoLCP:    "LCP",
+	protoPAP:    "PAP",
+	protoCHAP:   "CHAP",
+}
+
+func protoName(proto uint16) string {
+	if v, ok := protoNames[proto]; ok {
+		return v
+	}
+	return fmt.Sprintf("0x%04x", proto)
+}
+
+// control protocol codes, see RFC 1661
+const (
+	codeConfRequest = 1
+	codeConfAck     = 2
+	codeConfNak     = 3
+	codeConfReject  = 4
+	codeTermRequest = 5
+	codeTermAck     = 6
+	codeCodeReject  = 7
+	codeProtoReject = 8
+	codeEchoRequest = 9
+	codeEchoReply   = 10
+	codeDiscard     = 11
+)
+
+var codeNames = map[byte]string{
+	codeConfRequest: "Configure-Request",
+	codeConfAck:     "Configure-Ack",
+	codeConfNak:     "Configure-Nak",
+	codeConfReject:  "Configure-Reject",
+	codeTermRequest: "Terminate-Request",
+	codeTermAck:     "Terminate-Ack",
+	codeCodeReject:  "Code-Reject",
+	codeProtoReject: "Protocol-Reject",
+	codeEchoRequest: "Echo-Request",
+	codeEchoReply:   "Echo-Reply",
+	codeDiscard:     "Discard-Request",
+}
+
+// option names per control protocol
+var optionNames = map[uint16]map[byte]string{
+	protoLCP: {
+		1:  "MRU",
+		2:  "ACCM",
+		3:  "Auth-Protocol",
+		4:  "Quality-Protocol",
+		5:  "Magic-Number",
+		7:  "PFC",
+		8:  "ACFC",
+		13: "Callback",
+		17: "MRRU",
+		19: "Endpoint-Discriminator",
+		23: "Link-Discriminator",
+	},
+	protoIPCP: {
+		2:   "IP-Compression-Protocol",
+		3:   "IP-Address",
+		129: "Primary-DNS",
+		130: "Primary-NBNS",
+		131: "Secondary-DNS",
+		132: "Secondary-NBNS",
+	},
+	protoIPv6CP: {
+		1: "Interface-Identifier",
+		2: "IPv6-Compression-Protocol",
+	},
+}
+
+// packet prints a single PPP frame or an IP packet
+func (d *decoder) packet(indent int, data []byte) {
+	switch data[0] >> 4 {
+	case ipv4.Version, ipv6.Version:
+		d.ip(indent, data)
+	default:
+		d.ppp(indent, data)
+	}
+}
+
+// ppp prints a PPP frame with optional address/control and compressed
+// protocol fields
+func (d *decoder) ppp(indent int, frame []byte) {
+	if len(frame) >= 2 && frame[0] == 0xff && frame[1] == 0x03 {
+		frame = frame[2:]
+	}
+	if len(frame) == 0 {
+		d.printf(indent, "empty PPP frame\n")
+		return
+	}
+
+	var proto uint16
+	if frame[0]&1 == 1 {
+		// protocol field compression
+		proto = uint16(frame[0])
+		frame = frame[1:]
+	} else {
+		if len(frame) < 2 {
+			d.printf(indent, "truncated PPP protocol field: %x\n", frame)
+			return
+		}
+		proto = binary.BigEndian.Uint16(frame)
+		frame = frame[2:]
+	}
+
+	switch proto {
+	case protoIPv4, protoIPv6:
+		d.ip(indent, frame)
+	case protoLCP, protoIPCP, protoIPv6CP, protoCCP, protoPAP, protoCHAP:
+		d.control(indent, proto, frame)
+	default:
+		d.printf(indent, "PPP %s, %d bytes\n", protoName(proto), len(frame))
+	}
+}
+
+// control prints a PPP control protocol message
+func (d *decoder) control(indent int, proto uint16, data []byte) {
+	if len(data) < 4 {
+		d.printf(indent, "PPP %s truncated message: %x\n", protoName(proto), data)
+		return
+	}
+
+	code, id := data[0], data[1]
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < 4 || length > len(data) {
+		d.printf(indent, "PPP %s invalid message length %d of %d bytes: %x\n", protoName(proto), length, len(data), data)
+		return
+	}
+	data = data[4:length]
+
+	name, ok := codeNames[code]
+	if !ok || proto == protoPAP || proto == protoCHAP {
+		d.printf(indent, "PPP %s code=%d id=%d, %d bytes\n", protoName(proto), code, id, len(data))
+		return
+	}
+	d.printf(indent, "PPP %s %s id=%d\n", protoName(proto), name, id)
+
+	indent++
+	switch code {
+	case codeConfRequest, codeConfAck, codeConfNak, codeConfReject:
+		d.options(indent, proto, data)
+	case codeTermRequest, codeTermAck:
+		if len(data) > 0 {
+			d.printf(indent, "Data: %q\n", data)
+		}
+	case codeProtoReject:
+		if len(data) < 2 {
+			d.printf(indent, "truncated rejected protocol: %x\n", data)
+			return
+		}
+		d.printf(indent, "Rejected-Protocol: %s, %d bytes\n", protoName(binary.BigEndian.Uint16(data)), len(data)-2)
+	case codeEchoRequest, codeEchoReply, codeDiscard:
+		if len(data) < 4 {
+			d.printf(indent, "truncated magic number: %x\n", data)
+			return
+		}
+		d.printf(indent, "Magic-Number: 0x%08x\n", binary.BigEndian.Uint32(data))
+		if len(data) > 4 {
+			d.printf(indent, "Data: %x\n", data[4:])
+		}
+	default:
+		if len(data) > 0 {
+			d.printf(indent, "Data: %x\n", data)
+		}
+	}
+}
+
+// options prints the configuration options
+func (d *decoder) options(indent int, proto uint16, data []byte) {
+	for len(data) > 0 {
+		if len(data) < 2 || int(data[1]) < 2 || int(data[1]) > len(data) {
+			d.printf(indent, "invalid option: %x\n", data)
+			return
+		}
+		typ, value := data[0], data[2:data[1]]
+		data = data[data[1]:]
+
+		name, ok := optionNames[proto][typ]
+		if !ok {
+			name = fmt.Sprintf("Option %d", typ)
+		}
+		if v := optionValue(proto, typ, value); v != "" {
+			d.printf(indent, "%s: %s\n", name, v)
+		} else {
+			d.printf(indent, "%s\n", name)
+		}
+	}
+}
+
+func optionValue(proto uint16, typ byte, value []byte) string {
+	switch {
+	case proto == protoLCP && (typ == 1 || typ == 17) && len(value) == 2:
+		return fmt.Sprintf("%d", binary.BigEndian.Uint16(value))
+	case proto == protoLCP && (typ == 2 || typ == 5) && len(value) == 4:
+		return fmt.Sprintf("0x%08x", binary.BigEndian.Uint32(value))
+	case proto == protoLCP && (typ == 3 || typ == 4) && len(value) >= 2:
+		v := protoName(binary.BigEndian.Uint16(value))
+		if len(value) > 2 {
+			v += fmt.Sprintf(" %x", value[2:])
+		}
+		return v
+	case proto == protoIPCP && typ >= 3 && len(value) == net.IPv4len:
+		return net.IP(value).String()
+	case proto == protoIPv6CP && typ == 1 && len(value) == 8:
+		return net.IP(append([]byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0}, value...)).String()
+	case len(value) > 0:
+		return fmt.Sprintf("%x", value)
+	}
+	return ""
+}
+
+// ip prints an IP packet header
+func (d *decoder) ip(indent int, data []byte) {
+	if len(data) == 0 {
+		d.printf(indent, "empty IP packet\n")
+		return
+	}
+
+	switch data[0] >> 4 {
+	case ipv4.Version:
+		h, err := ipv4.ParseHeader(data)
+		if err != nil {
+			d.printf(indent, "invalid IPv4 header: %s\n", err)
+			return
+		}
+		d.printf(indent, "IPv4 %s > %s proto=%d ttl=%d len=%d%s\n", h.Src, h.Dst, h.Protocol, h.TTL, h.TotalLen, ports(h.Protocol, data[h.Len:]))
+	case ipv6.Version:
+		h, err := ipv6.ParseHeader(data)
+		if err != nil {
+			d.printf(indent, "invalid IPv6 header: %s\n", err)
+			return
+		}
+		d.printf(indent, "IPv6 %s > %s next=%d hop=%d len=%d%s\n", h.Src, h.Dst, h.NextHeader, h.HopLimit, h.PayloadLen, ports(h.NextHeader, data[ipv6.HeaderLen:]))
+	default:
+		d.printf(indent, "unknown IP version %d, %d bytes\n", data[0]>>4, len(data))
+	}
+}
+
+// transport protocol numbers
+const (
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+)
+
+// ports returns TCP/UDP ports and TCP flags
+func ports(proto int, data []byte) string {
+	switch proto {
+	case ipProtoTCP:
+		if len(data) < 14 {
+			return ""
+		}
+		return fmt.Sprintf(" tcp %d > %d [%s]", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]), tcpFlags(data[13]))
+	case ipProtoUDP:
+		if len(data) < 4 {
+			return ""
+		}
+		return fmt.Sprintf(" udp %d > %d", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]))
+	}
+	return ""
+}
+
+func tcpFlags(f byte) string {
+	var s []string
+	for i, v := range []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG"} {
+		if f&(1<<i) != 0 {
+			s = append(s, v)
+		}
+	}
+	return strings.Join(s, ",")
+}
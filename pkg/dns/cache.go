@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// maximum amount of cached answers
+	cacheSize = 4096
+	// maximum time to cache an answer, regardless of its TTL
+	maxCacheTTL = time.Hour
+)
+
+// cacheKey identifies the answer: the same question may have different
+// answers from the VPN and the local DNS servers
+type cacheKey struct {
+	servers string
+	name    string
+	qtype   uint16
+	qclass  uint16
+	// DNSSEC OK bit, the answer contains signatures
+	do bool
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// cache is an LRU cache of the positive and negative answers
+type cache struct {
+	sync.Mutex
+	size  int
+	ll    *list.List
+	items map[cacheKey]*list.Element
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[cacheKey]*list.Element),
+	}
+}
+
+func newCacheKey(m *dns.Msg, servers string) cacheKey {
+	q := m.Question[0]
+	key := cacheKey{
+		servers: servers,
+		name:    strings.ToLower(q.Name),
+		qtype:   q.Qtype,
+		qclass:  q.Qclass,
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		key.do = opt.Do()
+	}
+	return key
+}
+
+// get returns a copy of the cached answer with the TTLs decreased by the
+// answer age, or nil, when the answer is not cached or expired
+func (c *cache) get(key cacheKey, now time.Time) *dns.Msg {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return nil
+	}
+	c.ll.MoveToFront(e)
+
+	m := entry.msg.Copy()
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				// OPT TTL holds the extended flags
+				continue
+			}
+			if h := rr.Header(); h.Ttl > age {
+				h.Ttl -= age
+			} else {
+				h.Ttl = 0
+			}
+		}
+	}
+
+	return m
+}
+
+// set caches a copy of the answer, when it is cacheable
+func (c *cache) set(key cacheKey, m *dns.Msg, now time.Time) {
+	ttl, ok := cacheTTL(m)
+	if !ok {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	entry := &cacheEntry{
+		key:     key,
+		msg:     m.Copy(),
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*cacheEntry).key)
+	}
+}
+
+// flush removes all the cached answers
+func (c *cache) flush() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[cacheKey]*list.Element)
+}
+
+// cacheTTL returns the answer caching time: the minimal answer TTL for the
+// positive answers and the SOA TTL for the negative answers (RFC 2308).
+// Truncated, failed and negative answers without SOA are not cached.
+func cacheTTL(m *dns.Msg) (time.Duration, bool) {
+	if m.Truncated {
+		return 0, false
+	}
+
+	var ttl uint32
+	switch {
+	case m.Rcode == dns.RcodeSuccess && len(m.Answer) > 0:
+		ttl = m.Answer[0].Header().Ttl
+		for _, rr := range m.Answer[1:] {
+			if v := rr.Header().Ttl; v < ttl {
+				ttl = v
+			}
+		}
+	case m.Rcode == dns.RcodeSuccess, m.Rcode == dns.RcodeNameError:
+		var soa *dns.SOA
+		for _, rr := range m.Ns {
+			if v, ok := rr.(*dns.SOA); ok {
+				soa = v
+				break
+			}
+		}
+		if soa == nil {
+			return 0, false
+		}
+		ttl = soa.Hdr.Ttl
+		if soa.Minttl < ttl {
+			ttl = soa.Minttl
+		}
+	default:
+		return 0, false
+	}
+
+	if ttl == 0 {
+		return 0, false
+	}
+	if v := time.Duration(ttl) * time.Second; v < maxCacheTTL {
+		return v, true
+	}
+	return maxCacheTTL, true
+}
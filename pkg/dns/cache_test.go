@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newAnswer(rcode int, rrs ...string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("host.corp.example.com.", dns.TypeA)
+	m.Rcode = rcode
+	for _, v := range rrs {
+		rr, err := dns.NewRR(v)
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := rr.(*dns.SOA); ok {
+			m.Ns = append(m.Ns, rr)
+		} else {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	return m
+}
+
+func TestCacheTTL(t *testing.T) {
+	soa := "corp.example.com. 300 IN SOA ns.corp.example.com. admin.corp.example.com. 1 7200 3600 1209600 60"
+	truncated := newAnswer(dns.RcodeSuccess, "host.corp.example.com. 60 IN A 10.0.0.1")
+	truncated.Truncated = true
+
+	for i, v := range []struct {
+		msg   *dns.Msg
+		ttl   time.Duration
+		cache bool
+	}{
+		{newAnswer(dns.RcodeSuccess, "host.corp.example.com. 60 IN CNAME www.corp.example.com.", "www.corp.example.com. 30 IN A 10.0.0.1"), 30 * time.Second, true},
+		{newAnswer(dns.RcodeSuccess, "host.corp.example.com. 86400 IN A 10.0.0.1"), maxCacheTTL, true},
+		{newAnswer(dns.RcodeNameError, soa), 60 * time.Second, true},
+		{newAnswer(dns.RcodeSuccess, soa), 60 * time.Second, true},
+		{newAnswer(dns.RcodeNameError), 0, false},
+		{newAnswer(dns.RcodeServerFailure), 0, false},
+		{newAnswer(dns.RcodeSuccess, "host.corp.example.com. 0 IN A 10.0.0.1"), 0, false},
+		{truncated, 0, false},
+	} {
+		ttl, ok := cacheTTL(v.msg)
+		if ttl != v.ttl || ok != v.cache {
+			t.Errorf("%d: expected %s/%t, got %s/%t", i, v.ttl, v.cache, ttl, ok)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := newCache(2)
+	now := time.Now()
+	m := newAnswer(dns.RcodeSuccess, "host.corp.example.com. 60 IN A 10.0.0.1")
+	key := newCacheKey(m, "10.0.0.53")
+
+	c.set(key, m, now)
+	if r := c.get(key, now.Add(20*time.Second)); r == nil {
+		t.Errorf("expected a cached answer")
+	} else if ttl := r.Answer[0].Header().Ttl; ttl != 40 {
+		t.Errorf("expected 40 TTL, got %d", ttl)
+	}
+	if ttl := m.Answer[0].Header().Ttl; ttl != 60 {
+		t.Errorf("expected the original answer to be intact, got %d TTL", ttl)
+	}
+	if r := c.get(key, now.Add(time.Minute)); r != nil {
+		t.Errorf("expected an expired answer")
+	}
+
+	// the least recently used answer is evicted
+	keys := make([]cacheKey, 3)
+	for i := range keys {
+		keys[i] = newCacheKey(m, fmt.Sprintf("10.0.0.%d", i))
+		c.set(keys[i], m, now)
+		if i == 1 {
+			c.get(keys[0], now)
+		}
+	}
+	for i, expected := range []bool{true, false, true} {
+		if r := c.get(keys[i], now); (r != nil) != expected {
+			t.Errorf("%d: expected cached %t", i, expected)
+		}
+	}
+}
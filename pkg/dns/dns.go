@@ -4,20 +4,70 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/logging"
 
 	"github.com/miekg/dns"
 )
 
-func Start(cfg *config.Config, errChan chan error, tunDown chan struct{}) {
+// answers is the DNS proxy cache
+var answers = newCache(cacheSize)
+
+// proxy is a local DNS proxy, which is shared between simultaneous
+// connections
+var proxy struct {
+	sync.Mutex
+	configs []*config.Config
+	// the zones of all the connections and the original DNS servers, they
+	// are rebuilt, when a connection is registered or unregistered
+	zones  []zone
+	local  *upstreamGroup
+	srvUDP *dns.Server
+	srvTCP *dns.Server
+}
+
+// buildZones builds the zones of the registered connections, the proxy must
+// be locked
+func buildZones() {
+	local := &upstreamGroup{upstream: UpstreamLocal}
+	for _, cfg := range proxy.configs {
+		for _, s := range cfg.DNSServers {
+			if !containsIP(local.servers, s) {
+				local.servers = append(local.servers, s)
+			}
+		}
+	}
+
+	proxy.local = local
+	proxy.zones = connectionsZones(proxy.configs, local)
+}
+
+// Start registers the connection DNS zones and starts the DNS proxy, if it
+// is not started yet. The listener errors are sent to the errChan, when it is
+// not full, the connection may already wait for another error.
+func Start(cfg *config.Config, errChan chan error) {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	proxy.configs = append(proxy.configs, cfg)
+	buildZones()
+
+	if proxy.srvUDP != nil {
+		// already serving
+		return
+	}
+
+	openQueryLog(cfg)
+
 	dnsUDPHandler := func(w dns.ResponseWriter, m *dns.Msg) {
-		dnsHandler(w, m, cfg, "udp")
+		dnsHandler(w, m, "udp")
 	}
 
 	dnsTCPHandler := func(w dns.ResponseWriter, m *dns.Msg) {
-		dnsHandler(w, m, cfg, "tcp")
+		dnsHandler(w, m, "tcp")
 	}
 
 	listen := net.JoinHostPort(cfg.ListenDNS.String(), "53")
@@ -32,55 +82,145 @@ func Start(cfg *config.Config, errChan chan error, tunDown chan struct{}) {
 		Handler: dns.HandlerFunc(dnsTCPHandler),
 	}
 
-	go func() {
-		if err := srvUDP.ListenAndServe(); err != nil {
-			errChan <- fmt.Errorf("failed to set udp listener: %v", err)
-			return
-		}
-	}()
-	go func() {
-		if err := srvTCP.ListenAndServe(); err != nil {
-			errChan <- fmt.Errorf("failed to set tcp listener: %v", err)
-			return
+	serve := func(srv *dns.Server) {
+		if err := srv.ListenAndServe(); err != nil {
+			err = fmt.Errorf("failed to set %s listener: %v", srv.Net, err)
+			select {
+			case errChan <- err:
+			default:
+				log.Printf("DNS proxy: %s", err)
+			}
 		}
-	}()
-
-	go func() {
-		<-tunDown
-		log.Printf("Shutting down DNS proxy")
-		srvUDP.Shutdown()
-		srvTCP.Shutdown()
-	}()
+	}
+	go serve(srvUDP)
+	go serve(srvTCP)
+
+	proxy.srvUDP = srvUDP
+	proxy.srvTCP = srvTCP
 }
 
-func dnsHandler(w dns.ResponseWriter, m *dns.Msg, cfg *config.Config, proto string) {
-	c := new(dns.Client)
-	for _, suffix := range cfg.DNS {
-		if strings.HasSuffix(m.Question[0].Name, suffix) {
-			if cfg.Debug {
-				log.Printf("Resolving %q using VPN DNS", m.Question[0].Name)
-			}
-			for _, s := range cfg.F5Config.Object.DNS {
-				if err := handleCustom(w, m, c, s); err == nil {
-					return
-				}
-			}
+// Stop unregisters the connection DNS zones and shuts down the DNS proxy,
+// when there are no connections left
+func Stop(cfg *config.Config) {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	for i, v := range proxy.configs {
+		if v == cfg {
+			proxy.configs = append(proxy.configs[:i], proxy.configs[i+1:]...)
+			break
 		}
 	}
-	for _, s := range cfg.DNSServers {
-		if err := handleCustom(w, m, c, s); err == nil {
+	buildZones()
+
+	if len(proxy.configs) > 0 || proxy.srvUDP == nil {
+		return
+	}
+
+	log.Printf("Shutting down DNS proxy")
+	proxy.srvUDP.Shutdown()
+	proxy.srvTCP.Shutdown()
+	proxy.srvUDP = nil
+	proxy.srvTCP = nil
+	answers.flush()
+	closeQueryLog()
+}
+
+func dnsHandler(w dns.ResponseWriter, m *dns.Msg, proto string) {
+	if len(m.Question) == 0 {
+		r := new(dns.Msg)
+		r.SetRcode(m, dns.RcodeFormatError)
+		w.WriteMsg(r)
+		return
+	}
+	q := m.Question[0]
+	name := q.Name
+	start := time.Now()
+
+	proxy.Lock()
+	configs := append([]*config.Config(nil), proxy.configs...)
+	zones, local := proxy.zones, proxy.local
+	proxy.Unlock()
+
+	// the longest matching zones, the unmatched names are resolved by the
+	// original DNS servers. The zone names must not leak to the original DNS
+	// servers, when the zone DNS servers fail.
+	match, groups := matchZones(name, zones)
+	if len(groups) == 0 {
+		groups = []*upstreamGroup{local}
+	}
+
+	done := func(upstream string, rcode int, ok bool) {
+		countQuery(match, upstream, rcode, ok)
+		logQuery(newQueryRecord(q, match, upstream, rcode, ok, start))
+	}
+
+	if r := hostsAnswer(m, configs); r != nil {
+		logging.Debugf(logging.DNS, "Resolved %q from server host entries", name)
+		reply(w, m, r, proto)
+		done(UpstreamHosts, r.Rcode, true)
+		return
+	}
+
+	if q.Qtype == dns.TypeAAAA && filterAll(groups) {
+		logging.Debugf(logging.DNS, "Filtered %q AAAA query", name)
+		reply(w, m, noData(m), proto)
+		done(UpstreamFilter, dns.RcodeSuccess, true)
+		return
+	}
+
+	for _, g := range groups {
+		key := newCacheKey(m, g.key())
+		if r := answers.get(key, time.Now()); r != nil {
+			logging.Debugf(logging.DNS, "Resolved %q from cache", name)
+			if g.filterAAAA {
+				stripAAAA(r)
+			}
+			reply(w, m, r, proto)
+			done(UpstreamCache, r.Rcode, true)
 			return
 		}
+
+		logging.Debugf(logging.DNS, "Resolving %q using %s DNS %q", name, g.upstream, g.servers)
+		r, err := g.exchange(m, proto)
+		if err != nil {
+			logging.Debugf(logging.DNS, "Failed to resolve %q using %s DNS: %s", name, g.upstream, err)
+			continue
+		}
+		answers.set(key, r, time.Now())
+		if g.filterAAAA {
+			stripAAAA(r)
+		}
+		reply(w, m, r, proto)
+		done(g.upstream, r.Rcode, true)
+		return
 	}
+
+	// let the client fail fast instead of waiting for its own timeout
+	r := new(dns.Msg)
+	r.SetRcode(m, dns.RcodeServerFailure)
+	w.WriteMsg(r)
+	done(groups[0].upstream, 0, false)
 }
 
-func handleCustom(w dns.ResponseWriter, o *dns.Msg, c *dns.Client, ip net.IP) error {
-	m := new(dns.Msg)
-	o.CopyTo(m)
-	r, _, err := c.Exchange(m, net.JoinHostPort(ip.String(), "53"))
-	if r == nil || err != nil {
-		return fmt.Errorf("failed to resolve %q", m.Question[0].Name)
+// reply sends the answer, which fits into the client UDP buffer
+func reply(w dns.ResponseWriter, m, r *dns.Msg, proto string) {
+	r.Id = m.Id
+	if proto == "udp" {
+		size := dns.MinMsgSize
+		if opt := m.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		r.Truncate(size)
 	}
 	w.WriteMsg(r)
-	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
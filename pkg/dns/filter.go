@@ -0,0 +1,42 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// filterAll reports whether the AAAA queries of the zone are answered with
+// NODATA
+func filterAll(groups []*upstreamGroup) bool {
+	for _, g := range groups {
+		if g.filterAll {
+			return true
+		}
+	}
+	return false
+}
+
+// noData returns an empty answer, i.e. the name exists, but has no records
+// of the requested type
+func noData(m *dns.Msg) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(m)
+	r.RecursionAvailable = true
+	return r
+}
+
+// stripAAAA removes the AAAA records from the answer, an AAAA query answer
+// becomes NODATA
+func stripAAAA(r *dns.Msg) {
+	r.Answer = withoutAAAA(r.Answer)
+	r.Extra = withoutAAAA(r.Extra)
+}
+
+func withoutAAAA(rrs []dns.RR) []dns.RR {
+	res := rrs[:0]
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeAAAA {
+			res = append(res, rr)
+		}
+	}
+	return res
+}
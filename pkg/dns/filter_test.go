@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestStripAAAA(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("www.corp.", dns.TypeAAAA)
+	for _, v := range []string{
+		"www.corp. 60 IN CNAME web.corp.",
+		"web.corp. 60 IN AAAA 2001:db8::1",
+		"web.corp. 60 IN AAAA 2001:db8::2",
+	} {
+		rr, err := dns.NewRR(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Answer = append(r.Answer, rr)
+	}
+	r.SetEdns0(dns.DefaultMsgSize, false)
+
+	stripAAAA(r)
+	if len(r.Answer) != 1 || r.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Errorf("expected only the CNAME record, got %v", r.Answer)
+	}
+	if r.IsEdns0() == nil {
+		t.Errorf("expected the OPT record to be kept")
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		t.Errorf("expected NODATA, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestFilterAll(t *testing.T) {
+	newConfig := func(name string, fullTunnel, ipv6 bool) *config.Config {
+		return &config.Config{
+			DNS:        []string{name},
+			FilterAAAA: config.FilterAAAAAll,
+			FullTunnel: fullTunnel,
+			IPv6:       ipv6,
+			F5Config: &config.Favorite{
+				Object: config.Object{IPv6: config.Bool(ipv6)},
+			},
+		}
+	}
+
+	for _, v := range []struct {
+		configs  []*config.Config
+		filtered bool
+	}{
+		{[]*config.Config{newConfig("full.corp", true, false), newConfig("split.corp", false, false)}, true},
+		{[]*config.Config{newConfig("split.corp", false, false)}, false},
+		{[]*config.Config{newConfig("ipv6.corp", true, true)}, false},
+	} {
+		local := &upstreamGroup{upstream: UpstreamLocal}
+		zones := connectionsZones(v.configs, local)
+		for _, name := range []string{"host.full.corp.", "host.split.corp.", "host.ipv6.corp.", "example.com."} {
+			_, groups := matchZones(name, zones)
+			if len(groups) == 0 {
+				groups = []*upstreamGroup{local}
+			}
+			if filterAll(groups) != v.filtered {
+				t.Errorf("%s: expected filtered %t", name, v.filtered)
+			}
+		}
+	}
+}
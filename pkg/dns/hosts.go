@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/miekg/dns"
+)
+
+// hostsTTL is the TTL of the server pushed host entries
+const hostsTTL = 60
+
+// hostsAnswer returns the answer to the A or AAAA query of a server pushed
+// host entry name, or nil, when the name is not known
+func hostsAnswer(m *dns.Msg, configs []*config.Config) *dns.Msg {
+	q := m.Question[0]
+	if q.Qclass != dns.ClassINET || q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return nil
+	}
+
+	var found bool
+	r := new(dns.Msg)
+	r.SetReply(m)
+	r.RecursionAvailable = true
+	hdr := dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    hostsTTL,
+	}
+	for _, cfg := range configs {
+		if cfg.DisableHosts || cfg.F5Config == nil {
+			continue
+		}
+		for _, h := range cfg.F5Config.Object.Hosts {
+			if !containsName(h.Names, q.Name) {
+				continue
+			}
+			// the other address family name gets an empty answer
+			found = true
+			if v := h.IP.To4(); v != nil && q.Qtype == dns.TypeA {
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: v})
+			} else if v == nil && q.Qtype == dns.TypeAAAA {
+				r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: h.IP})
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	return r
+}
+
+func containsName(names []string, name string) bool {
+	for _, v := range names {
+		if strings.EqualFold(dns.Fqdn(v), name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/logging"
+
+	"github.com/miekg/dns"
+)
+
+// queryLog is the optional DNS proxy query log, which can be toggled at
+// runtime
+var queryLog struct {
+	sync.Mutex
+	enabled bool
+	// the config state is applied once, the runtime state is kept on
+	// reconnect
+	configured bool
+	// JSON lines file, the queries are logged by the logger when nil
+	file *os.File
+}
+
+// queryRecord is the DNS proxy query log record
+type queryRecord struct {
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Zone     string    `json:"zone,omitempty"`
+	Upstream string    `json:"upstream,omitempty"`
+	Rcode    string    `json:"rcode"`
+	Latency  float64   `json:"latencyMs"`
+	Cached   bool      `json:"cached"`
+}
+
+func newQueryRecord(q dns.Question, zone, upstream string, rcode int, ok bool, start time.Time) queryRecord {
+	rec := queryRecord{
+		Time:     start,
+		Name:     q.Name,
+		Type:     dns.TypeToString[q.Qtype],
+		Zone:     zone,
+		Upstream: upstream,
+		Rcode:    outcomeFailed,
+		Latency:  float64(time.Since(start).Microseconds()) / 1000,
+		Cached:   upstream == UpstreamCache,
+	}
+	if ok {
+		rec.Rcode = strings.ToLower(dns.RcodeToString[rcode])
+	}
+	if rec.Type == "" {
+		rec.Type = fmt.Sprintf("TYPE%d", q.Qtype)
+	}
+	return rec
+}
+
+// openQueryLog opens the configured query log file
+func openQueryLog(cfg *config.Config) {
+	queryLog.Lock()
+	defer queryLog.Unlock()
+
+	if !queryLog.configured {
+		queryLog.enabled = cfg.DNSQueryLog.Enabled
+		queryLog.configured = true
+	}
+	if cfg.DNSQueryLog.File == "" {
+		return
+	}
+
+	f, err := os.OpenFile(cfg.DNSQueryLog.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Failed to open %q DNS query log file, the queries are logged by the logger: %s", cfg.DNSQueryLog.File, err)
+		return
+	}
+	// windows preserves the original user parameters, no need to chown
+	if runtime.GOOS != "windows" {
+		if err = f.Chown(cfg.Uid, cfg.Gid); err != nil {
+			f.Close()
+			log.Printf("Failed to set an owner for the %q DNS query log file, the queries are logged by the logger: %s", cfg.DNSQueryLog.File, err)
+			return
+		}
+	}
+	queryLog.file = f
+}
+
+// closeQueryLog closes the query log file
+func closeQueryLog() {
+	queryLog.Lock()
+	defer queryLog.Unlock()
+
+	if queryLog.file != nil {
+		queryLog.file.Close()
+		queryLog.file = nil
+	}
+}
+
+// SetQueryLog enables or disables the DNS proxy query log
+func SetQueryLog(enabled bool) {
+	queryLog.Lock()
+	defer queryLog.Unlock()
+
+	queryLog.enabled = enabled
+	if enabled {
+		log.Printf("DNS query log is enabled")
+	} else {
+		log.Printf("DNS query log is disabled")
+	}
+}
+
+// QueryLogEnabled reports whether the DNS proxy query log is enabled
+func QueryLogEnabled() bool {
+	queryLog.Lock()
+	defer queryLog.Unlock()
+	return queryLog.enabled
+}
+
+// logQuery writes the query record, when the query log is enabled
+func logQuery(rec queryRecord) {
+	queryLog.Lock()
+	defer queryLog.Unlock()
+
+	if !queryLog.enabled {
+		return
+	}
+
+	if queryLog.file != nil {
+		if err := json.NewEncoder(queryLog.file).Encode(rec); err != nil {
+			log.Printf("Failed to write DNS query log: %s", err)
+		}
+		return
+	}
+
+	slog.Info("DNS query",
+		logging.SubsystemKey, logging.DNS,
+		"name", rec.Name,
+		"type", rec.Type,
+		"zone", rec.Zone,
+		"upstream", rec.Upstream,
+		"rcode", rec.Rcode,
+		"latencyMs", rec.Latency,
+		"cached", rec.Cached,
+	)
+}
@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// minimal prefixes of the networks, which get the reverse zones, the shorter
+// networks cover the public address space, e.g. the full tunnel or the split
+// exclude routes
+const (
+	minReversePrefix4 = 8
+	minReversePrefix6 = 16
+)
+
+// ReverseZones returns the in-addr.arpa and ip6.arpa zones, which cover the
+// networks. The zones are delegated on the label boundaries: octets for IPv4
+// and nibbles for IPv6, i.e. a network with a prefix between the boundaries
+// is split into several zones, e.g. 192.168.0.0/23 is covered by the
+// 0.168.192.in-addr.arpa. and 1.168.192.in-addr.arpa. zones. The networks
+// shorter than /8 (IPv4) or /16 (IPv6) are skipped.
+func ReverseZones(nets []*net.IPNet) []string {
+	var zones []string
+	seen := make(map[string]bool)
+	for _, n := range nets {
+		ones, bits := n.Mask.Size()
+		var units []int
+		var step int
+		var suffix, format string
+		if ip := n.IP.Mask(n.Mask).To4(); ip != nil && bits == 8*net.IPv4len {
+			if ones < minReversePrefix4 {
+				continue
+			}
+			for _, v := range ip {
+				units = append(units, int(v))
+			}
+			step, suffix, format = 8, "in-addr.arpa.", "%d"
+		} else if ip := n.IP.Mask(n.Mask).To16(); ip != nil && bits == 8*net.IPv6len {
+			if ones < minReversePrefix6 {
+				continue
+			}
+			for _, v := range ip {
+				units = append(units, int(v>>4), int(v&0xf))
+			}
+			step, suffix, format = 4, "ip6.arpa.", "%x"
+		} else {
+			continue
+		}
+
+		// round the prefix up to the label boundary
+		labels := (ones + step - 1) / step
+		for i := 0; i < 1<<(labels*step-ones); i++ {
+			zone := make([]string, 0, labels+1)
+			for j := labels - 1; j >= 0; j-- {
+				v := units[j]
+				if j == labels-1 {
+					v += i
+				}
+				zone = append(zone, fmt.Sprintf(format, v))
+			}
+			zone = append(zone, suffix)
+			if v := strings.Join(zone, "."); !seen[v] {
+				seen[v] = true
+				zones = append(zones, v)
+			}
+		}
+	}
+	return zones
+}
@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestReverseZones(t *testing.T) {
+	for _, v := range []struct {
+		cidrs []string
+		zones []string
+	}{
+		{[]string{"10.0.0.0/8"}, []string{"10.in-addr.arpa."}},
+		{[]string{"10.1.2.3/32"}, []string{"3.2.1.10.in-addr.arpa."}},
+		{[]string{"192.168.0.0/23", "172.16.0.0/12"}, []string{
+			"0.168.192.in-addr.arpa.", "1.168.192.in-addr.arpa.",
+			"16.172.in-addr.arpa.", "17.172.in-addr.arpa.", "18.172.in-addr.arpa.", "19.172.in-addr.arpa.",
+			"20.172.in-addr.arpa.", "21.172.in-addr.arpa.", "22.172.in-addr.arpa.", "23.172.in-addr.arpa.",
+			"24.172.in-addr.arpa.", "25.172.in-addr.arpa.", "26.172.in-addr.arpa.", "27.172.in-addr.arpa.",
+			"28.172.in-addr.arpa.", "29.172.in-addr.arpa.", "30.172.in-addr.arpa.", "31.172.in-addr.arpa.",
+		}},
+		{[]string{"0.0.0.0/0"}, nil},
+		{[]string{"0.0.0.0/7", "10.0.0.0/8"}, []string{"10.in-addr.arpa."}},
+		{[]string{"2000::/3"}, nil},
+		{[]string{"2001:db8::/32"}, []string{"8.b.d.0.1.0.0.2.ip6.arpa."}},
+		{[]string{"2001:db8::/30"}, []string{
+			"8.b.d.0.1.0.0.2.ip6.arpa.", "9.b.d.0.1.0.0.2.ip6.arpa.",
+			"a.b.d.0.1.0.0.2.ip6.arpa.", "b.b.d.0.1.0.0.2.ip6.arpa.",
+		}},
+		{[]string{"10.0.0.0/24", "10.0.0.0/24"}, []string{"0.0.10.in-addr.arpa."}},
+	} {
+		var nets []*net.IPNet
+		for _, c := range v.cidrs {
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nets = append(nets, n)
+		}
+		if zones := ReverseZones(nets); !reflect.DeepEqual(zones, v.zones) {
+			t.Errorf("%q: expected %q, got %q", v.cidrs, v.zones, zones)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DNS proxy upstreams
+const (
+	UpstreamVPN   = "vpn"
+	UpstreamLocal = "local"
+	// the dnsZones servers
+	UpstreamCustom = "custom"
+	// answered from the server pushed host entries
+	UpstreamHosts = "hosts"
+	// AAAA query, answered with NODATA
+	UpstreamFilter = "filter"
+	// answered from the DNS proxy cache
+	UpstreamCache = "cache"
+)
+
+// outcomeFailed is the outcome of a query, which was not answered by any
+// upstream
+const outcomeFailed = "failed"
+
+// defaultZone holds the queries, which don't belong to the configured zones
+const defaultZone = "default"
+
+// QueryStats holds the amount of the DNS proxy queries, answered by the
+// upstream with the outcome, i.e. the lowercase response code
+type QueryStats struct {
+	Upstream string `json:"upstream"`
+	Outcome  string `json:"outcome"`
+	Count    uint64 `json:"count"`
+}
+
+// ZoneStats holds the amount of the DNS proxy queries of the zone
+type ZoneStats struct {
+	Zone    string `json:"zone"`
+	Queries uint64 `json:"queries"`
+	// answered from the DNS proxy cache
+	Cached uint64 `json:"cached"`
+	// not answered by any upstream
+	Failed uint64 `json:"failed"`
+}
+
+type queryKey struct {
+	upstream string
+	outcome  string
+}
+
+var queries struct {
+	sync.Mutex
+	counters map[queryKey]uint64
+	zones    map[string]*ZoneStats
+}
+
+// countQuery counts the DNS proxy query of the zone
+func countQuery(zone, upstream string, rcode int, ok bool) {
+	outcome := outcomeFailed
+	if ok {
+		outcome = strings.ToLower(dns.RcodeToString[rcode])
+	}
+
+	queries.Lock()
+	defer queries.Unlock()
+	if queries.counters == nil {
+		queries.counters = make(map[queryKey]uint64)
+	}
+	queries.counters[queryKey{upstream, outcome}]++
+
+	if zone == "" {
+		zone = defaultZone
+	}
+	if queries.zones == nil {
+		queries.zones = make(map[string]*ZoneStats)
+	}
+	z, found := queries.zones[zone]
+	if !found {
+		z = &ZoneStats{Zone: zone}
+		queries.zones[zone] = z
+	}
+	z.Queries++
+	switch {
+	case upstream == UpstreamCache:
+		z.Cached++
+	case outcome == outcomeFailed:
+		z.Failed++
+	}
+}
+
+// Stats returns the DNS proxy query counters, sorted by the upstream and the
+// outcome
+func Stats() []QueryStats {
+	queries.Lock()
+	res := make([]QueryStats, 0, len(queries.counters))
+	for k, v := range queries.counters {
+		res = append(res, QueryStats{
+			Upstream: k.upstream,
+			Outcome:  k.outcome,
+			Count:    v,
+		})
+	}
+	queries.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Upstream != res[j].Upstream {
+			return res[i].Upstream < res[j].Upstream
+		}
+		return res[i].Outcome < res[j].Outcome
+	})
+
+	return res
+}
+
+// Zones returns the DNS proxy query counters by zone, sorted by the zone
+func Zones() []ZoneStats {
+	queries.Lock()
+	res := make([]ZoneStats, 0, len(queries.zones))
+	for _, v := range queries.zones {
+		res = append(res, *v)
+	}
+	queries.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Zone < res[j].Zone
+	})
+
+	return res
+}
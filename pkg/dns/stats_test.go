@@ -0,0 +1,23 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZones(t *testing.T) {
+	countQuery("corp.", UpstreamVPN, dns.RcodeSuccess, true)
+	countQuery("corp.", UpstreamCache, dns.RcodeNameError, true)
+	countQuery("corp.", UpstreamVPN, 0, false)
+	countQuery("", UpstreamLocal, dns.RcodeSuccess, true)
+
+	expected := []ZoneStats{
+		{Zone: "corp.", Queries: 3, Cached: 1, Failed: 1},
+		{Zone: defaultZone, Queries: 1},
+	}
+	if zones := Zones(); !reflect.DeepEqual(zones, expected) {
+		t.Errorf("expected %+v, got %+v", expected, zones)
+	}
+}
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// upstreamTimeout is the time budget to get an answer from an upstream group
+const upstreamTimeout = 3 * time.Second
+
+// upstreamGroup is a set of equivalent DNS servers, which are raced
+type upstreamGroup struct {
+	// UpstreamVPN or UpstreamLocal
+	upstream string
+	servers  []net.IP
+	// strip the AAAA records from the answers, the tunnel has no IPv6
+	filterAAAA bool
+	// answer the AAAA queries with NODATA, a full tunnel has no IPv6
+	filterAll bool
+}
+
+// key returns the group identity for the answers cache
+func (g *upstreamGroup) key() string {
+	s := make([]string, 0, len(g.servers))
+	for _, v := range g.servers {
+		s = append(s, v.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// exchange races the group servers over the client protocol and returns the
+// first usable answer. A server failure or a refusal is returned only when
+// all the servers fail.
+func (g *upstreamGroup) exchange(m *dns.Msg, proto string) (*dns.Msg, error) {
+	if len(g.servers) == 0 {
+		return nil, fmt.Errorf("no %s DNS servers", g.upstream)
+	}
+
+	type result struct {
+		msg *dns.Msg
+		err error
+	}
+	deadline := time.Now().Add(upstreamTimeout)
+	results := make(chan result, len(g.servers))
+	for _, s := range g.servers {
+		// the message is packed concurrently
+		go func(m *dns.Msg, addr string) {
+			r, err := exchangeServer(m, addr, proto, deadline)
+			results <- result{r, err}
+		}(m.Copy(), net.JoinHostPort(s.String(), "53"))
+	}
+
+	var failed *dns.Msg
+	var err error
+	for range g.servers {
+		res := <-results
+		if res.err != nil {
+			err = res.err
+			continue
+		}
+		switch res.msg.Rcode {
+		case dns.RcodeServerFailure, dns.RcodeRefused:
+			failed = res.msg
+			continue
+		}
+		return res.msg, nil
+	}
+	if failed != nil {
+		return failed, nil
+	}
+
+	return nil, err
+}
+
+// exchangeServer sends the query and retries over TCP, when the UDP answer is
+// truncated
+func exchangeServer(m *dns.Msg, addr, proto string, deadline time.Time) (*dns.Msg, error) {
+	c := &dns.Client{
+		Net:     proto,
+		Timeout: time.Until(deadline),
+	}
+	r, _, err := c.Exchange(m, addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.Truncated && proto == "udp" {
+		c = &dns.Client{
+			Net:     "tcp",
+			Timeout: time.Until(deadline),
+		}
+		r, _, err = c.Exchange(m, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/miekg/dns"
+)
+
+// zone is a DNS zone, forwarded to the upstream group
+type zone struct {
+	// lowercase FQDN, "." is the root zone
+	name  string
+	group *upstreamGroup
+}
+
+func newZone(name string, group *upstreamGroup) zone {
+	return zone{
+		name:  dns.Fqdn(strings.ToLower(strings.Trim(name, "."))),
+		group: group,
+	}
+}
+
+// configZones returns the connection zones: the dnsZones first, then the dns
+// and the reverse zones, which are forwarded to the VPN DNS servers. All the
+// names are forwarded to the VPN DNS servers, when the zones are not set.
+func configZones(cfg *config.Config, local *upstreamGroup) []zone {
+	vpn := &upstreamGroup{
+		upstream:   UpstreamVPN,
+		servers:    cfg.F5Config.Object.DNS,
+		filterAAAA: cfg.FilterAAAA != "" && !tunnelIPv6(cfg),
+		filterAll:  filtersAll(cfg),
+	}
+	zones := make([]zone, 0, len(cfg.DNSZones)+len(cfg.DNS)+len(cfg.ReverseZones))
+	for name, v := range cfg.DNSZones {
+		switch v.Keyword {
+		case config.DNSUpstreamVPN:
+			zones = append(zones, newZone(name, vpn))
+		case config.DNSUpstreamLocal:
+			zones = append(zones, newZone(name, local))
+		default:
+			zones = append(zones, newZone(name, &upstreamGroup{upstream: UpstreamCustom, servers: v.Servers}))
+		}
+	}
+	for _, name := range cfg.DNS {
+		zones = append(zones, newZone(name, vpn))
+	}
+	for _, name := range cfg.ReverseZones {
+		zones = append(zones, newZone(name, vpn))
+	}
+	if len(cfg.DNSZones)+len(cfg.DNS) == 0 {
+		zones = append(zones, newZone(".", vpn))
+	}
+	return zones
+}
+
+// connectionsZones returns the zones of all the connections. When a full
+// tunnel connection has no IPv6, the AAAA queries of all the upstream groups,
+// including the original DNS servers, are answered with NODATA, otherwise the
+// IPv6 traffic would leave through the local IPv6 uplink.
+func connectionsZones(configs []*config.Config, local *upstreamGroup) []zone {
+	var zones []zone
+	var all bool
+	for _, cfg := range configs {
+		zones = append(zones, configZones(cfg, local)...)
+		all = all || filtersAll(cfg)
+	}
+	if all {
+		local.filterAll = true
+		for _, z := range zones {
+			z.group.filterAll = true
+		}
+	}
+	return zones
+}
+
+// tunnelIPv6 reports whether the connection tunnel has IPv6
+func tunnelIPv6(cfg *config.Config) bool {
+	return cfg.IPv6 && bool(cfg.F5Config.Object.IPv6)
+}
+
+// filtersAll reports whether the connection answers all the AAAA queries with
+// NODATA, i.e. the full tunnel has no IPv6
+func filtersAll(cfg *config.Config) bool {
+	return cfg.FilterAAAA == config.FilterAAAAAll && cfg.FullTunnel && !tunnelIPv6(cfg)
+}
+
+// matchZones returns the longest zone, which contains the name, and the zone
+// upstream groups. Zones are matched on the label boundaries, i.e. "evilcorp."
+// doesn't belong to the "corp." zone.
+func matchZones(name string, zones []zone) (string, []*upstreamGroup) {
+	var match string
+	var groups []*upstreamGroup
+	longest := -1
+	for _, z := range zones {
+		if !dns.IsSubDomain(z.name, name) {
+			continue
+		}
+		switch n := dns.CountLabel(z.name); {
+		case n > longest:
+			longest = n
+			match = z.name
+			groups = []*upstreamGroup{z.group}
+		case n == longest && !containsGroup(groups, z.group):
+			groups = append(groups, z.group)
+		}
+	}
+	return match, groups
+}
+
+func containsGroup(groups []*upstreamGroup, g *upstreamGroup) bool {
+	for _, v := range groups {
+		if v == g {
+			return true
+		}
+	}
+	return false
+}
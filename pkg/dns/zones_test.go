@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func TestMatchZones(t *testing.T) {
+	vpn := &upstreamGroup{upstream: UpstreamVPN}
+	lab := &upstreamGroup{upstream: UpstreamCustom}
+	local := &upstreamGroup{upstream: UpstreamLocal}
+	other := &upstreamGroup{upstream: UpstreamVPN}
+
+	zones := []zone{
+		newZone(".corp.", vpn),
+		newZone("lab.corp", lab),
+		newZone("Public.Lab.Corp.", local),
+		newZone(".corp.", other),
+		newZone("example.com.", vpn),
+	}
+
+	for _, v := range []struct {
+		name   string
+		zone   string
+		groups []*upstreamGroup
+	}{
+		{"corp.", "corp.", []*upstreamGroup{vpn, other}},
+		{"host.corp.", "corp.", []*upstreamGroup{vpn, other}},
+		{"host.lab.corp.", "lab.corp.", []*upstreamGroup{lab}},
+		{"lab.corp.", "lab.corp.", []*upstreamGroup{lab}},
+		{"www.public.lab.corp.", "public.lab.corp.", []*upstreamGroup{local}},
+		{"HOST.LAB.CORP.", "lab.corp.", []*upstreamGroup{lab}},
+		{"host.evilcorp.", "", nil},
+		{"host.notlab.corp.", "corp.", []*upstreamGroup{vpn, other}},
+		{"example.com.", "example.com.", []*upstreamGroup{vpn}},
+		{"example.org.", "", nil},
+	} {
+		zone, groups := matchZones(v.name, zones)
+		if zone != v.zone {
+			t.Errorf("%s: expected %q zone, got %q", v.name, v.zone, zone)
+		}
+		if len(groups) != len(v.groups) {
+			t.Errorf("%s: expected %d groups, got %d", v.name, len(v.groups), len(groups))
+			continue
+		}
+		for i := range groups {
+			if groups[i] != v.groups[i] {
+				t.Errorf("%s: unexpected %d group", v.name, i)
+			}
+		}
+	}
+
+	root := []zone{newZone(".", lab), newZone("corp", vpn)}
+	if _, groups := matchZones("example.org.", root); len(groups) != 1 || groups[0] != lab {
+		t.Errorf("expected the root zone to match all the names")
+	}
+	if _, groups := matchZones("host.corp.", root); len(groups) != 1 || groups[0] != vpn {
+		t.Errorf("expected the longest zone to win over the root zone")
+	}
+}
+
+func TestFilterAllLocalZone(t *testing.T) {
+	cfg := &config.Config{
+		DNSZones: map[string]config.DNSUpstream{
+			"corp": {Keyword: config.DNSUpstreamVPN},
+			"lan":  {Keyword: config.DNSUpstreamLocal},
+			"lab":  {Servers: []net.IP{net.ParseIP("192.168.1.53")}},
+		},
+		FilterAAAA: config.FilterAAAAAll,
+		FullTunnel: true,
+		F5Config:   &config.Favorite{},
+	}
+	local := &upstreamGroup{upstream: UpstreamLocal}
+	zones := connectionsZones([]*config.Config{cfg}, local)
+
+	// the full tunnel carries the traffic of the locally resolved names too
+	for _, name := range []string{"host.corp.", "host.lan.", "host.lab.", "example.com."} {
+		_, groups := matchZones(name, zones)
+		if len(groups) == 0 {
+			groups = []*upstreamGroup{local}
+		}
+		if !filterAll(groups) {
+			t.Errorf("%s: expected the AAAA query to be filtered", name)
+		}
+	}
+}
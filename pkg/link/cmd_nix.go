@@ -4,12 +4,12 @@
 package link
 
 import (
-	"log"
 	"os/exec"
 	"runtime"
 	"syscall"
 
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/logging"
 )
 
 func Cmd(cfg *config.Config) *exec.Cmd {
@@ -28,12 +28,12 @@ func Cmd(cfg *config.Config) *exec.Cmd {
 				"noipv6", // Unsupported protocol 'IPv6 Control Protocol' (0x8057) received
 			)
 		}
-		if cfg.Debug {
+		if logging.Enabled(logging.PPP) {
 			cfg.PPPdArgs = append(cfg.PPPdArgs,
 				"debug",
 				"kdebug", "1",
 			)
-			log.Printf("pppd args: %q", cfg.PPPdArgs)
+			logging.Debugf(logging.PPP, "pppd args: %q", cfg.PPPdArgs)
 		}
 
 		switch runtime.GOOS {
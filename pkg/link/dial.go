@@ -0,0 +1,282 @@
+package link
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/pin"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/elliptic"
+)
+
+const (
+	// dialTimeout is the connection timeout for a single server address
+	dialTimeout = 10 * time.Second
+	// dialStagger is the delay before the next server address is tried,
+	// while the previous attempts are still in progress (RFC 8305)
+	dialStagger = 250 * time.Millisecond
+)
+
+// lastServerIP remembers the server address, which was used to establish the
+// last tunnel. It is tried first, when the tunnel is reconnected.
+var lastServerIP sync.Map
+
+// lookupServer returns the server addresses in the order they should be
+// dialled. The addresses, defined in the config, take precedence over DNS.
+func lookupServer(host string, override []net.IP) ([]net.IP, error) {
+	ips := override
+	if len(ips) == 0 {
+		var err error
+		ips, err = net.LookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return nil, fmt.Errorf("failed to resolve %s: %s", host, err)
+		}
+	}
+
+	var preferred net.IP
+	if v, ok := lastServerIP.Load(host); ok {
+		preferred = v.(net.IP)
+	}
+
+	return sortIPs(ips, preferred), nil
+}
+
+// sortIPs orders the addresses according to RFC 8305: address families are
+// interleaved, starting with IPv6. The preferred address goes first and
+// defines the first address family.
+func sortIPs(ips []net.IP, preferred net.IP) []net.IP {
+	var v4, v6 []net.IP
+	first := &v6
+	for _, ip := range ips {
+		list := &v6
+		if ip.To4() != nil {
+			list = &v4
+		}
+		if preferred != nil && ip.Equal(preferred) {
+			// prepend the preferred address
+			*list = append([]net.IP{ip}, *list...)
+			first = list
+			continue
+		}
+		*list = append(*list, ip)
+	}
+
+	second := &v4
+	if first == &v4 {
+		second = &v6
+	}
+
+	res := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(*first) || i < len(*second); i++ {
+		if i < len(*first) {
+			res = append(res, (*first)[i])
+		}
+		if i < len(*second) {
+			res = append(res, (*second)[i])
+		}
+	}
+
+	return res
+}
+
+// dialServer races the server addresses and returns the first established
+// connection. The next address is tried, when the previous attempt fails or
+// doesn't succeed within the stagger delay.
+func dialServer(ctx context.Context, host, port string, ips []net.IP, dial func(ctx context.Context, addr string) (io.ReadWriteCloser, error)) (io.ReadWriteCloser, error) {
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no %s addresses", host)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ip   net.IP
+		addr string
+		conn io.ReadWriteCloser
+		err  error
+	}
+	results := make(chan result, len(ips))
+
+	var next int
+	var stagger <-chan time.Time
+	start := func() {
+		ip := ips[next]
+		next++
+		addr := net.JoinHostPort(ip.String(), port)
+		if len(ips) > 1 {
+			log.Printf("Trying %s (%s)", addr, host)
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+			conn, err := dial(ctx, addr)
+			results <- result{ip, addr, conn, err}
+		}()
+		stagger = nil
+		if next < len(ips) {
+			stagger = time.After(dialStagger)
+		}
+	}
+
+	var errs []error
+	start()
+	for pending := 1; pending > 0; {
+		select {
+		case <-stagger:
+			start()
+			pending++
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				log.Printf("Failed to connect to %s: %s", r.addr, r.err)
+				errs = append(errs, r.err)
+				if next < len(ips) {
+					start()
+					pending++
+				}
+				continue
+			}
+			lastServerIP.Store(host, r.ip)
+			// close the connections, which are established too late
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if r := <-results; r.conn != nil {
+						r.conn.Close()
+					}
+				}
+			}(pending)
+			return r.conn, nil
+		}
+	}
+
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, fmt.Errorf("all %d %s addresses failed, last error: %s", len(errs), host, errs[len(errs)-1])
+}
+
+// dialTLS establishes a TLS connection with the server
+func dialTLS(ctx context.Context, dialer *net.Dialer, host, port string, ips []net.IP, tlsConfig *tls.Config) (io.ReadWriteCloser, error) {
+	conf := tlsConfig.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName = host
+	}
+	d := &tls.Dialer{
+		NetDialer: dialer,
+		Config:    conf,
+	}
+
+	return dialServer(ctx, host, port, ips, func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// dialDTLS establishes a DTLS connection with the server
+func dialDTLS(ctx context.Context, dialer *net.Dialer, host, port string, ips []net.IP, tlsConfig *tls.Config, verifier *pin.Verifier) (io.ReadWriteCloser, error) {
+	serverName := host
+	if tlsConfig.ServerName != "" {
+		serverName = tlsConfig.ServerName
+	}
+	conf := &dtls.Config{
+		RootCAs:            tlsConfig.RootCAs,
+		Certificates:       tlsConfig.Certificates,
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+		ServerName:         serverName,
+		CipherSuites:       dtlsCipherSuites(tlsConfig.CipherSuites),
+		EllipticCurves:     dtlsCurves(tlsConfig.CurvePreferences),
+		KeyLogWriter:       tlsConfig.KeyLogWriter,
+	}
+	if verifier != nil {
+		conf.VerifyPeerCertificate = verifier.VerifyPeerCertificate(serverName)
+	}
+
+	return dialServer(ctx, host, port, ips, func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		conn, err := dialer.DialContext(ctx, "udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		dtlsConn, err := dtls.ClientWithContext(ctx, conn, conf)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return dtlsConn, nil
+	})
+}
+
+// dtlsCipherSuites returns the cipher suites, supported by DTLS
+func dtlsCipherSuites(ids []uint16) []dtls.CipherSuiteID {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	supported := make(map[uint16]bool)
+	for _, v := range dtls.CipherSuites() {
+		supported[v.ID] = true
+	}
+
+	var res []dtls.CipherSuiteID
+	for _, id := range ids {
+		if supported[id] {
+			res = append(res, dtls.CipherSuiteID(id))
+		}
+	}
+	if len(res) == 0 {
+		log.Printf("None of the configured cipher suites is supported by DTLS, using defaults")
+	}
+
+	return res
+}
+
+// dtlsCurves returns the elliptic curves, supported by DTLS
+func dtlsCurves(ids []tls.CurveID) []elliptic.Curve {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var res []elliptic.Curve
+	for _, id := range ids {
+		switch c := elliptic.Curve(id); c {
+		case elliptic.X25519, elliptic.P256, elliptic.P384:
+			res = append(res, c)
+		}
+	}
+	if len(res) == 0 {
+		log.Printf("None of the configured curves is supported by DTLS, using defaults")
+	}
+
+	return res
+}
+
+// DialContext returns an http.Transport dial function, which connects to the
+// server using the addresses defined in the config
+func DialContext(server string, ips []net.IP) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host != server {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		var errs []error
+		for _, ip := range sortIPs(ips, nil) {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %s", addr, errs[len(errs)-1])
+	}
+}
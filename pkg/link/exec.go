@@ -0,0 +1,34 @@
+package link
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+)
+
+// runCommand executes the command with the environment and the timeout, the
+// command output is logged with the prefix
+func runCommand(prefix string, env []string, timeout time.Duration, name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = env
+	// don't wait for the background processes, which inherited the output
+	cmd.WaitDelay = time.Second
+
+	out, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		log.Printf("%s: %s", prefix, scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	return err
+}
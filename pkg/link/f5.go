@@ -9,6 +9,9 @@ import (
 	"log"
 	"net"
 
+	"github.com/kayrus/gof5/pkg/logging"
+	"github.com/kayrus/gof5/pkg/pcap"
+
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 )
@@ -22,6 +25,7 @@ func readBuf(buf, sep []byte) []byte {
 }
 
 var (
+	f5Header  = []byte{0xf5, 0x00}
 	ppp       = []byte{0xff, 0x03}
 	pppLCP    = []byte{0xc0, 0x21}
 	pppIPCP   = []byte{0x80, 0x21}
@@ -69,43 +73,53 @@ func bytesToIPv6(bytes []byte) net.IP {
 	return net.IP(append([]byte{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, append(bytes[:0:0], bytes...)...))
 }
 
-func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
+func processPPP(l *Link, buf []byte, dstBuf *bytes.Buffer) error {
 	// process ipv4 traffic
 	if v := readBuf(buf, ipv4header); v != nil {
-		if l.debug {
-			log.Printf("Read parsed ipv4 %d bytes from http:\n%s", len(v), hex.Dump(v))
+		if logging.Enabled(logging.Packets) {
+			logging.Debugf(logging.Packets, "Read parsed ipv4 %d bytes from http:\n%s", len(v), hex.Dump(v))
 			header, _ := ipv4.ParseHeader(v)
-			log.Printf("ipv4 from http: %s", header)
+			logging.Debugf(logging.Packets, "ipv4 from http: %s", header)
 		}
+		l.pcap.WriteIP(pcap.Inbound, v)
+		l.stats.rx.ipv4.add(len(v))
 
 		wn, err := l.iface.Write(v)
 		if err != nil {
+			l.stats.tunWriteErrors.Add(1)
 			return fmt.Errorf("fatal write to tun: %s", err)
 		}
-		if l.debug {
-			log.Printf("Sent %d bytes to tun", wn)
+		if logging.Enabled(logging.Packets) {
+			logging.Debugf(logging.Packets, "Sent %d bytes to tun", wn)
 		}
 		return nil
 	}
 
 	// process ipv6 traffic
 	if v := readBuf(buf, ipv6header); v != nil {
-		if l.debug {
-			log.Printf("Read parsed ipv6 %d bytes from http:\n%s", len(v), hex.Dump(v))
+		if logging.Enabled(logging.Packets) {
+			logging.Debugf(logging.Packets, "Read parsed ipv6 %d bytes from http:\n%s", len(v), hex.Dump(v))
 			header, _ := ipv6.ParseHeader(v)
-			log.Printf("ipv6 from http: %s", header)
+			logging.Debugf(logging.Packets, "ipv6 from http: %s", header)
 		}
+		l.pcap.WriteIP(pcap.Inbound, v)
+		l.stats.rx.ipv6.add(len(v))
 
 		wn, err := l.iface.Write(v)
 		if err != nil {
+			l.stats.tunWriteErrors.Add(1)
 			return fmt.Errorf("fatal write to tun: %s", err)
 		}
-		if l.debug {
-			log.Printf("Sent %d bytes to tun", wn)
+		if logging.Enabled(logging.Packets) {
+			logging.Debugf(logging.Packets, "Sent %d bytes to tun", wn)
 		}
 		return nil
 	}
 
+	// PPP control frames
+	l.pcap.WritePPP(pcap.Inbound, buf)
+	l.stats.rx.ppp.add(len(buf))
+
 	// TODO: support IPv4 only
 	if v := readBuf(buf, pppIPCP); v != nil {
 		if v := readBuf(v, confRequest); v != nil {
@@ -285,8 +299,8 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 			}
 			if v := readBuf(v, echoReq); v != nil {
 				id := v[0]
-				if l.debug {
-					log.Printf("id: %d, echo", id)
+				if logging.Enabled(logging.PPP) {
+					logging.Debugf(logging.PPP, "id: %d, echo", id)
 				}
 				// live pings
 				doResp := &bytes.Buffer{}
@@ -299,6 +313,10 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 
 				return toF5(l, doResp.Bytes(), dstBuf)
 			}
+			if v := readBuf(v, echoRep); v != nil {
+				l.echoReply(v[0])
+				return nil
+			}
 			if v := readBuf(v, protoReject); v != nil {
 				id := v[0]
 				if v := readBuf(v[1:], protoRej); v != nil {
@@ -425,10 +443,11 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 		}
 	}
 
+	l.stats.decodeErrors.Add(1)
 	return fmt.Errorf("unknown PPP data:\n%s", hex.Dump(buf))
 }
 
-func fromF5(l *vpnLink, dstBuf *bytes.Buffer) error {
+func fromF5(l *Link, dstBuf *bytes.Buffer) error {
 	// read the F5 packet header
 	buf := make([]byte, 2)
 	_, err := io.ReadFull(l.HTTPConn, buf)
@@ -436,6 +455,7 @@ func fromF5(l *vpnLink, dstBuf *bytes.Buffer) error {
 		return fmt.Errorf("failed to read F5 packet header: %s", err)
 	}
 	if !(buf[0] == 0xf5 && buf[1] == 00) {
+		l.stats.decodeErrors.Add(1)
 		return fmt.Errorf("incorrect F5 header: %x", buf)
 	}
 
@@ -455,6 +475,7 @@ func fromF5(l *vpnLink, dstBuf *bytes.Buffer) error {
 	if n != int(pkglen) {
 		return fmt.Errorf("incorrect F5 packet size: %d, expected: %d", n, pkglen)
 	}
+	l.stats.rx.tunnel.add(len(f5Header) + 2 + n)
 
 	// process the packet
 	return processPPP(l, buf, dstBuf)
@@ -462,7 +483,7 @@ func fromF5(l *vpnLink, dstBuf *bytes.Buffer) error {
 
 // Decode F5 packet
 // http->tun
-func (l *vpnLink) HttpToTun() {
+func (l *Link) HttpToTun() {
 	dstBuf := &bytes.Buffer{}
 	for {
 		select {
@@ -478,7 +499,7 @@ func (l *vpnLink) HttpToTun() {
 	}
 }
 
-func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
+func toF5(l *Link, buf []byte, dst *bytes.Buffer) error {
 	// TODO: move buffer initialization into tunToHTTP
 	// probably a buffered pipe would be nicer
 	length := len(buf)
@@ -496,7 +517,7 @@ func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 		length += len(ipv6header)
 	}
 
-	_, err := dst.Write([]byte{0xf5, 0x00})
+	_, err := dst.Write(f5Header)
 	if err != nil {
 		return fmt.Errorf("failed to write F5 header: %s", err)
 	}
@@ -515,8 +536,20 @@ func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 		return fmt.Errorf("failed to write IP header: %s", err)
 	}
 
-	if l.debug {
-		log.Printf("Sending from pppd:\n%s", hex.Dump(buf))
+	if logging.Enabled(logging.Packets) {
+		logging.Debugf(logging.Packets, "Sending from pppd:\n%s", hex.Dump(buf))
+	}
+
+	switch buf[0] >> 4 {
+	case ipv4.Version:
+		l.pcap.WriteIP(pcap.Outbound, buf)
+		l.stats.tx.ipv4.add(len(buf))
+	case ipv6.Version:
+		l.pcap.WriteIP(pcap.Outbound, buf)
+		l.stats.tx.ipv6.add(len(buf))
+	default:
+		l.pcap.WritePPP(pcap.Outbound, buf)
+		l.stats.tx.ppp.add(len(buf))
 	}
 
 	_, err = dst.Write(buf)
@@ -527,8 +560,9 @@ func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 	if err != nil {
 		return fmt.Errorf("fatal write to http: %s", err)
 	}
-	if l.debug {
-		log.Printf("Sent %d bytes to http", wn)
+	l.stats.tx.tunnel.add(int(wn))
+	if logging.Enabled(logging.Packets) {
+		logging.Debugf(logging.Packets, "Sent %d bytes to http", wn)
 	}
 
 	return nil
@@ -536,7 +570,7 @@ func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 
 // Encode into F5 packet
 // tun->http
-func (l *vpnLink) TunToHTTP() {
+func (l *Link) TunToHTTP() {
 	buf := make([]byte, bufferSize)
 	dstBuf := &bytes.Buffer{}
 	for {
@@ -551,10 +585,10 @@ func (l *vpnLink) TunToHTTP() {
 				}
 				return
 			}
-			if l.debug {
-				log.Printf("Read %d bytes from tun:\n%s", rn, hex.Dump(buf[:rn]))
+			if logging.Enabled(logging.Packets) {
+				logging.Debugf(logging.Packets, "Read %d bytes from tun:\n%s", rn, hex.Dump(buf[:rn]))
 				header, _ := ipv4.ParseHeader(buf[:rn])
-				log.Printf("ipv4 from tun: %s", header)
+				logging.Debugf(logging.Packets, "ipv4 from tun: %s", header)
 			}
 
 			err = toF5(l, buf[:rn], dstBuf)
@@ -0,0 +1,106 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// hook names
+const (
+	hookPreUp    = "preUp"
+	hookPostUp   = "postUp"
+	hookPreDown  = "preDown"
+	hookPostDown = "postDown"
+)
+
+// runHooks executes the hook commands one by one. An error is returned, when
+// a command fails and the failure policy is abort.
+func (l *Link) runHooks(cfg *config.Config, hook string, cmds []string, info Info) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	env := append(os.Environ(), hookEnv(cfg, hook, info, l.mtuInt)...)
+	for _, v := range cmds {
+		log.Printf("Executing %s hook: %s", hook, v)
+		if err := runHook(hook, v, env, cfg.Hooks.Timeout); err != nil {
+			err = fmt.Errorf("%s hook %q failed: %s", hook, v, err)
+			if cfg.Hooks.OnFailure == config.HooksAbort {
+				return err
+			}
+			log.Print(err)
+		}
+	}
+
+	return nil
+}
+
+func runHook(hook, command string, env []string, timeout time.Duration) error {
+	if runtime.GOOS == "windows" {
+		return runCommand(hook, env, timeout, "cmd", "/C", command)
+	}
+	return runCommand(hook, env, timeout, "/bin/sh", "-c", command)
+}
+
+// hookEnv returns the hook environment variables, empty values are omitted
+func hookEnv(cfg *config.Config, hook string, info Info, mtu uint16) []string {
+	var env []string
+	add := func(name string, values ...string) {
+		if v := strings.TrimSpace(strings.Join(values, " ")); v != "" {
+			env = append(env, name+"="+v)
+		}
+	}
+
+	add("GOF5_HOOK", hook)
+	add("GOF5_CONNECTION", cfg.Name)
+	add("GOF5_INTERFACE", info.Interface)
+	add("GOF5_NETNS", cfg.Netns)
+	add("GOF5_LOCAL_IPV4", ipString(info.LocalIPv4))
+	add("GOF5_REMOTE_IPV4", ipString(info.ServerIPv4))
+	add("GOF5_LOCAL_IPV6", ipString(info.LocalIPv6))
+	add("GOF5_REMOTE_IPV6", ipString(info.ServerIPv6))
+	if mtu > 0 {
+		add("GOF5_MTU", strconv.Itoa(int(mtu)))
+	}
+
+	if cfg.F5Config != nil {
+		var dns []string
+		for _, v := range cfg.F5Config.Object.DNS {
+			dns = append(dns, v.String())
+		}
+		add("GOF5_DNS", dns...)
+		add("GOF5_DNS_SUFFIXES", cfg.F5Config.Object.DNSSuffix...)
+	}
+
+	// routes are not applied yet in preUp hooks
+	routes := info.Routes
+	if routes == nil {
+		if cfg.Routes != nil {
+			routes = cfg.Routes.GetNetworks()
+		} else if cfg.F5Config != nil && cfg.F5Config.Object.Routes != nil {
+			routes = cfg.F5Config.Object.Routes.GetNetworks()
+		}
+	}
+	var cidrs []string
+	for _, v := range routes {
+		cidrs = append(cidrs, v.String())
+	}
+	add("GOF5_ROUTES", cidrs...)
+
+	return env
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
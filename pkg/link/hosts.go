@@ -0,0 +1,174 @@
+package link
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/miekg/dns"
+)
+
+// hostsFile serializes the simultaneous connections hosts file updates
+var hostsFile sync.Mutex
+
+func hostsPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// hostsMarkers returns the lines, which delimit the connection host entries
+func hostsMarkers(name string) (string, string) {
+	suffix := "gof5"
+	if name != "" {
+		suffix += " " + name
+	}
+	return "# BEGIN " + suffix, "# END " + suffix
+}
+
+// removeHostsBlock removes the delimited block from the hosts file content,
+// a block without the end marker is kept
+func removeHostsBlock(content []byte, name string) []byte {
+	begin, end := hostsMarkers(name)
+	var buf, block bytes.Buffer
+	var inside bool
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		switch strings.TrimSpace(line) {
+		case begin:
+			if inside {
+				// a nested begin marker, keep the unterminated block
+				buf.Write(block.Bytes())
+				block.Reset()
+			}
+			inside = true
+			block.WriteString(line)
+			continue
+		case end:
+			if inside {
+				inside = false
+				block.Reset()
+				continue
+			}
+		}
+		if inside {
+			block.WriteString(line)
+		} else {
+			buf.WriteString(line)
+		}
+	}
+	buf.Write(block.Bytes())
+	return buf.Bytes()
+}
+
+// appendHostsBlock appends the delimited host entries to the hosts file
+// content
+func appendHostsBlock(content []byte, name string, hosts []config.Host) []byte {
+	begin, end := hostsMarkers(name)
+	buf := bytes.NewBuffer(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	fmt.Fprintln(buf, begin)
+	for _, h := range hosts {
+		fmt.Fprintf(buf, "%s\t%s\n", h.IP, strings.Join(h.Names, " "))
+	}
+	fmt.Fprintln(buf, end)
+	return buf.Bytes()
+}
+
+// zoneHosts returns the host entries names, which belong to the VPN zones,
+// other names must not override the names outside the tunnel
+func zoneHosts(cfg *config.Config) []config.Host {
+	zones := vpnZones(cfg)
+	var hosts []config.Host
+	for _, h := range cfg.F5Config.Object.Hosts {
+		var names []string
+		for _, name := range h.Names {
+			if inZones(name, zones) {
+				names = append(names, name)
+			} else {
+				log.Printf("Ignoring %q server host entry outside the %q DNS zones", name, zones)
+			}
+		}
+		if len(names) > 0 {
+			hosts = append(hosts, config.Host{IP: h.IP, Names: names})
+		}
+	}
+	return hosts
+}
+
+func inZones(name string, zones []string) bool {
+	for _, zone := range zones {
+		if dns.IsSubDomain(dns.Fqdn(strings.Trim(zone, ".")), dns.Fqdn(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateHosts rewrites the hosts file in place, the file may be a bind mount
+func updateHosts(update func([]byte) []byte) error {
+	hostsFile.Lock()
+	defer hostsFile.Unlock()
+
+	path := hostsPath()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read hosts file: %s", err)
+	}
+	if err := ioutil.WriteFile(path, update(content), 0644); err != nil {
+		return fmt.Errorf("failed to write hosts file: %s", err)
+	}
+	return nil
+}
+
+// addHosts writes the server pushed host entries to the hosts file, when the
+// DNS proxy doesn't serve them
+func (l *Link) addHosts(cfg *config.Config) error {
+	hosts := cfg.F5Config.Object.Hosts
+	if cfg.DisableHosts || len(hosts) == 0 || l.sharedResolv {
+		return nil
+	}
+	if cfg.Helper != nil || l.netns != nil {
+		log.Printf("Ignoring %d server host entries, the hosts file cannot be modified", len(hosts))
+		return nil
+	}
+
+	log.Printf("Adding %d server host entries to %s", len(hosts), hostsPath())
+	err := updateHosts(func(content []byte) []byte {
+		// a leftover of the unclean shutdown is replaced
+		return appendHostsBlock(removeHostsBlock(content, cfg.Name), cfg.Name, hosts)
+	})
+	if err != nil {
+		return err
+	}
+	l.hostsAdded = true
+
+	return nil
+}
+
+// removeHosts removes the server pushed host entries from the hosts file
+func (l *Link) removeHosts(cfg *config.Config) {
+	if !l.hostsAdded {
+		return
+	}
+
+	log.Printf("Removing server host entries from %s", hostsPath())
+	err := updateHosts(func(content []byte) []byte {
+		return removeHostsBlock(content, cfg.Name)
+	})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	l.hostsAdded = false
+}
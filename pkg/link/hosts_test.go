@@ -0,0 +1,64 @@
+package link
+
+import (
+	"net"
+	"testing"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func TestHostsBlock(t *testing.T) {
+	original := "127.0.0.1\tlocalhost\n::1\tlocalhost"
+	hosts := []config.Host{
+		{IP: net.ParseIP("10.0.0.1"), Names: []string{"files.corp", "files"}},
+		{IP: net.ParseIP("2001:db8::1"), Names: []string{"wiki.corp"}},
+	}
+
+	content := appendHostsBlock([]byte(original), "customer-a", hosts)
+	expected := original + "\n# BEGIN gof5 customer-a\n10.0.0.1\tfiles.corp files\n2001:db8::1\twiki.corp\n# END gof5 customer-a\n"
+	if string(content) != expected {
+		t.Fatalf("expected %q, got %q", expected, content)
+	}
+
+	// another connection block is kept
+	content = appendHostsBlock(content, "", hosts[:1])
+	if v := string(removeHostsBlock(content, "customer-a")); v != original+"\n# BEGIN gof5\n10.0.0.1\tfiles.corp files\n# END gof5\n" {
+		t.Errorf("unexpected content: %q", v)
+	}
+	if v := string(removeHostsBlock(removeHostsBlock(content, ""), "customer-a")); v != original+"\n" {
+		t.Errorf("unexpected content: %q", v)
+	}
+
+	// a block without the end marker is not removed
+	unterminated := original + "\n# BEGIN gof5\n10.0.0.1\tfiles.corp\n"
+	if v := string(removeHostsBlock([]byte(unterminated), "")); v != unterminated {
+		t.Errorf("unexpected content: %q", v)
+	}
+}
+
+func TestZoneHosts(t *testing.T) {
+	cfg := &config.Config{
+		DNS: []string{".corp."},
+		DNSZones: map[string]config.DNSUpstream{
+			"lab.example.com": {Keyword: config.DNSUpstreamVPN},
+			"example.com":     {Keyword: config.DNSUpstreamLocal},
+		},
+		F5Config: &config.Favorite{},
+	}
+	cfg.F5Config.Object.Hosts = []config.Host{
+		{IP: net.ParseIP("10.0.0.1"), Names: []string{"files.corp", "files.evilcorp"}},
+		{IP: net.ParseIP("10.0.0.2"), Names: []string{"www.example.com"}},
+		{IP: net.ParseIP("10.0.0.3"), Names: []string{"Wiki.Lab.Example.Com"}},
+	}
+
+	hosts := zoneHosts(cfg)
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 host entries, got %v", hosts)
+	}
+	if len(hosts[0].Names) != 1 || hosts[0].Names[0] != "files.corp" {
+		t.Errorf("unexpected names: %q", hosts[0].Names)
+	}
+	if !hosts[1].IP.Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("unexpected %s host entry", hosts[1].IP)
+	}
+}
@@ -2,6 +2,7 @@ package link
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -10,30 +11,59 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kayrus/gof5/pkg/config"
 	"github.com/kayrus/gof5/pkg/dns"
-
-	"github.com/fatih/color"
+	"github.com/kayrus/gof5/pkg/logging"
+	"github.com/kayrus/gof5/pkg/pcap"
+	"github.com/kayrus/gof5/pkg/proxy"
+	"github.com/kayrus/gof5/pkg/sandbox"
+	"github.com/kayrus/gof5/pkg/sdnotify"
+	"github.com/kayrus/gof5/pkg/util"
+
+	"github.com/IBM/netaddr"
 	"github.com/kayrus/tuncfg/resolv"
 	"github.com/kayrus/tuncfg/route"
 	"github.com/kayrus/tuncfg/tun"
-	"github.com/pion/dtls/v2"
+	xproxy "golang.org/x/net/proxy"
+	wgtun "golang.zx2c4.com/wireguard/tun"
 )
 
 const (
 	// TUN MTU should not be bigger than buffer size
 	bufferSize   = 1500
+	defaultPort  = "443"
 	userAgentVPN = "Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; Trident/6.0; F5 Networks Client)"
+	// the reverse zones of the fragmented routes are not configured
+	maxReverseZones = 256
 )
 
-var colorlog = log.New(color.Error, "", log.LstdFlags)
+// systemResolv is the system resolver configuration, which points to the
+// local DNS proxy. It is shared between simultaneous connections: the first
+// connection configures it and the last one restores it.
+var systemResolv struct {
+	sync.Mutex
+	handler *resolv.Handler
+	// search suffixes of all the connections
+	suffixes []string
+	refs     int
+}
 
-type vpnLink struct {
+// routeManager adds and removes VPN routes
+type routeManager interface {
+	Add()
+	Del()
+}
+
+// Link is a VPN link, which forwards the traffic between the F5 tunnel and
+// the local interface
+type Link struct {
 	sync.Mutex
 	HTTPConn    io.ReadWriteCloser
 	ErrChan     chan error
@@ -44,17 +74,55 @@ type vpnLink struct {
 	// pppUp is used to wait for the PPP handshake (wireguard only)
 	pppUp chan struct{}
 	// tunUp is used to wait for the TUN interface (wireguard and pppd)
-	tunUp         chan struct{}
-	serverIPs     []net.IP
+	tunUp     chan struct{}
+	serverIPs []net.IP
+	proxyIPs  []net.IP
+	// the login server addresses, when the tunnel host differs
+	loginIPs      []net.IP
 	localIPv4     net.IP
 	serverIPv4    net.IP
 	localIPv6     net.IP
 	serverIPv6    net.IP
 	mtu           []byte
 	mtuInt        uint16
-	debug         bool
-	routeHandler  *route.Handler
+	routeHandler  routeManager
 	resolvHandler *resolv.Handler
+	// sharedResolv is true, when resolvHandler is the shared system resolver
+	// configuration
+	sharedResolv bool
+	// Linux only, named network namespace, which contains the interface
+	netns *namespace
+	// optional packet capture
+	pcap *pcap.Writer
+	// pppd only, HDLC stream decoders for the packet capture
+	pcapHTTP io.WriteCloser
+	pcapPPPD io.WriteCloser
+	// routes, applied to the interface
+	routes []*net.IPNet
+	// up hooks have been started
+	hooksUp atomic.Bool
+	// the network is configured by the vpnc-script
+	vpncConnected bool
+	// DNS is configured by the privileged helper
+	helperDNS bool
+	// server host entries are added to the hosts file
+	hostsAdded bool
+	// traffic and link statistics
+	stats stats
+	// the link info snapshot, which is published by configure, the link
+	// lock is held during the whole configuration including the hooks
+	infoLock sync.Mutex
+	infoSnap Info
+}
+
+// Info holds the link interface, addresses and routes
+type Info struct {
+	Interface  string
+	LocalIPv4  net.IP
+	ServerIPv4 net.IP
+	LocalIPv6  net.IP
+	ServerIPv6 net.IP
+	Routes     []*net.IPNet
 }
 
 func randomHostname(n int) []byte {
@@ -69,10 +137,18 @@ func randomHostname(n int) []byte {
 	return b
 }
 
-// init a TLS connection
-func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*vpnLink, error) {
+// init a TLS connection, the connection is aborted, when the context is
+// cancelled
+func InitConnection(ctx context.Context, server string, cfg *config.Config, tlsConfig *tls.Config) (*Link, error) {
+	host, port := tunnelEndpoint(server, &cfg.F5Config.Object)
+	addr := net.JoinHostPort(host, port)
+	urlHost := addr
+	if port == defaultPort {
+		urlHost = host
+	}
+
 	getURL := fmt.Sprintf("https://%s/myvpn?sess=%s&hostname=%s&hdlc_framing=%s&ipv4=%s&ipv6=%s&Z=%s",
-		server,
+		urlHost,
 		cfg.F5Config.Object.SessionID,
 		base64.StdEncoding.EncodeToString(randomHostname(8)),
 		config.Bool(cfg.Driver == "pppd"),
@@ -81,46 +157,91 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 		cfg.F5Config.Object.UrZ,
 	)
 
-	serverIPs, err := net.LookupIP(server)
-	if err != nil || len(serverIPs) == 0 {
-		return nil, fmt.Errorf("failed to resolve %s: %s", server, err)
+	// the config addresses belong to the login server
+	override := cfg.ServerIPs
+	var loginIPs []net.IP
+	var err error
+	if loginHost, _ := splitServer(server); !strings.EqualFold(loginHost, host) {
+		override = nil
+		// the login server is still used to refresh the session
+		loginIPs, err = lookupServer(loginHost, cfg.ServerIPs)
+		if err != nil {
+			log.Printf("Failed to exclude the login server from the VPN routes: %s", err)
+		}
+	}
+
+	serverIPs, err := lookupServer(host, override)
+	if err != nil {
+		return nil, err
 	}
 
 	// define link channels
-	l := &vpnLink{
+	l := &Link{
 		ErrChan:     make(chan error, 1),
 		TunDown:     make(chan struct{}, 1),
 		PppdErrChan: make(chan error, 1),
 		serverIPs:   serverIPs,
+		loginIPs:    loginIPs,
 		pppUp:       make(chan struct{}, 1),
 		tunUp:       make(chan struct{}, 1),
-		debug:       cfg.Debug,
 	}
 
-	if cfg.DTLS && cfg.F5Config.Object.TunnelDTLS {
-		s := fmt.Sprintf("%s:%s", server, cfg.F5Config.Object.TunnelPortDTLS)
-		log.Printf("Connecting to %s using DTLS", s)
-		addr, err := net.ResolveUDPAddr("udp", s)
+	if cfg.Pcap != "" {
+		l.pcap, err = pcap.New(cfg.Pcap, cfg.PcapMaxSize, cfg.PcapMaxFiles, cfg.Uid, cfg.Gid)
 		if err != nil {
-			return nil, fmt.Errorf("failed to resolve UDP address: %s", err)
+			return nil, err
 		}
-		conf := &dtls.Config{
-			RootCAs:            tlsConfig.RootCAs,
-			Certificates:       tlsConfig.Certificates,
-			InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
-			ServerName:         server,
+		log.Printf("Capturing tunnel packets into %q", cfg.Pcap)
+		if cfg.Driver == "pppd" {
+			l.pcapHTTP = l.pcap.HDLCTap(pcap.Inbound)
+			l.pcapPPPD = l.pcap.HDLCTap(pcap.Outbound)
+		}
+	}
+
+	dialer := &net.Dialer{}
+	if cfg.PolicyRouting.Table != 0 {
+		// bypass the VPN routing table
+		dialer.Control = markSocket(cfg.PolicyRouting.FwMark)
+	}
+
+	useDTLS := cfg.DTLS && cfg.F5Config.Object.TunnelDTLS
+	if useDTLS && cfg.ProxyURL != nil {
+		log.Printf("DTLS cannot be used through a proxy, using TLS")
+		useDTLS = false
+	}
+	if useDTLS && (tlsConfig.MinVersion > tls.VersionTLS12 || tlsConfig.MaxVersion != 0 && tlsConfig.MaxVersion < tls.VersionTLS12) {
+		log.Printf("DTLS supports only version 1.2, which is out of the configured TLS versions range, using TLS")
+		useDTLS = false
+	}
+
+	if useDTLS {
+		log.Printf("Connecting to %s using DTLS", net.JoinHostPort(host, cfg.F5Config.Object.TunnelPortDTLS))
+		l.HTTPConn, err = dialDTLS(ctx, dialer, host, cfg.F5Config.Object.TunnelPortDTLS, serverIPs, tlsConfig, cfg.PinVerifier)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial %s: %s", host, err)
+		}
+	} else if cfg.ProxyURL != nil {
+		l.HTTPConn, err = dialProxy(ctx, cfg.ProxyURL, dialer, addr, tlsConfig)
+		if err != nil {
+			return nil, err
 		}
-		l.HTTPConn, err = dtls.Dial("udp", addr, conf)
+		// exclude the proxy from the VPN routes
+		l.proxyIPs, err = proxy.LookupIP(cfg.ProxyURL)
 		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s:%s: %s", server, cfg.F5Config.Object.TunnelPortDTLS, err)
+			log.Printf("failed to resolve %s proxy: %s", cfg.ProxyURL.Hostname(), err)
 		}
 	} else {
-		l.HTTPConn, err = tls.Dial("tcp", fmt.Sprintf("%s:443", server), tlsConfig)
+		log.Printf("Connecting to %s", addr)
+		l.HTTPConn, err = dialTLS(ctx, dialer, host, port, serverIPs, tlsConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s:443: %s", server, err)
+			return nil, fmt.Errorf("failed to dial %s: %s", addr, err)
 		}
 	}
 
+	// abort the VPN session request
+	stop := context.AfterFunc(ctx, func() { l.HTTPConn.Close() })
+	defer stop()
+
 	req, err := http.NewRequest("GET", getURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create VPN session request: %s", err)
@@ -131,8 +252,8 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 		return nil, fmt.Errorf("failed to send VPN session request: %s", err)
 	}
 
-	if l.debug {
-		log.Printf("URL: %s", redactSess(getURL))
+	if logging.Enabled(logging.HTTP) {
+		logging.Debugf(logging.HTTP, "URL: %s", redactSess(getURL))
 	}
 
 	resp, err := http.ReadResponse(bufio.NewReader(l.HTTPConn), nil)
@@ -146,20 +267,81 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 	l.localIPv6 = net.ParseIP(resp.Header.Get("X-VPN-client-IPv6"))
 	l.serverIPv6 = net.ParseIP(resp.Header.Get("X-VPN-server-IPv6"))
 
-	if l.debug {
-		log.Printf("Client IP: %s", l.localIPv4)
-		log.Printf("Server IP: %s", l.serverIPv4)
+	if logging.Enabled(logging.PPP) {
+		logging.Debugf(logging.PPP, "Client IP: %s", l.localIPv4)
+		logging.Debugf(logging.PPP, "Server IP: %s", l.serverIPv4)
 		if l.localIPv6 != nil {
-			log.Printf("Client IPv6: %s", l.localIPv6)
+			logging.Debugf(logging.PPP, "Client IPv6: %s", l.localIPv6)
 		}
 		if l.localIPv6 != nil {
-			log.Printf("Server IPv6: %s", l.serverIPv6)
+			logging.Debugf(logging.PPP, "Server IPv6: %s", l.serverIPv6)
 		}
 	}
 
 	return l, nil
 }
 
+// splitServer returns the server host and port, the default port is used,
+// when the server has no port
+func splitServer(server string) (string, string) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// server has no port
+		return strings.Trim(server, "[]"), defaultPort
+	}
+	return host, port
+}
+
+// tunnelEndpoint returns the tunnel host and port. The tunnel host and port,
+// defined in the connection profile, take precedence over the login server.
+func tunnelEndpoint(server string, o *config.Object) (string, string) {
+	host, port := splitServer(server)
+
+	if v := o.TunnelHost; v != "" {
+		if h, p, err := net.SplitHostPort(v); err == nil {
+			host, port = h, p
+		} else {
+			host = strings.Trim(v, "[]")
+		}
+	}
+
+	if o.TunnelPort != "" {
+		port = o.TunnelPort
+	}
+
+	return host, port
+}
+
+// dialProxy establishes a TLS connection through the proxy
+func dialProxy(ctx context.Context, u *url.URL, dialer *net.Dialer, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	log.Printf("Connecting to %s through %s proxy", addr, u.Redacted())
+	d, err := proxy.Dialer(u, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s proxy dialer: %s", u.Redacted(), err)
+	}
+	var conn net.Conn
+	if cd, ok := d.(xproxy.ContextDialer); ok {
+		conn, err = cd.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %s", addr, err)
+	}
+
+	conf := tlsConfig.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, conf)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to establish TLS connection with %s: %s", addr, err)
+	}
+
+	return tlsConn, nil
+}
+
 func redactSess(u string) string {
 	if !strings.Contains(u, "sess=") {
 		return u
@@ -175,7 +357,7 @@ func redactSess(u string) string {
 	return parts[0] + "sess=[REDACTED]"
 }
 
-func (l *vpnLink) createTunDevice() error {
+func (l *Link) createTunDevice(cfg *config.Config) error {
 	if l.mtuInt+tun.Offset > bufferSize {
 		return fmt.Errorf("MTU exceeds the %d buffer limit", bufferSize)
 	}
@@ -188,6 +370,9 @@ func (l *vpnLink) createTunDevice() error {
 	case "windows":
 		ifname = "gof5"
 	}
+	if cfg.TunName != "" {
+		ifname = cfg.TunName
+	}
 
 	local := &net.IPNet{
 		IP:   l.localIPv4,
@@ -197,35 +382,75 @@ func (l *vpnLink) createTunDevice() error {
 		IP:   l.serverIPv4,
 		Mask: net.CIDRMask(32, 32),
 	}
-	tunDev, err := tun.OpenTunDevice(local, gw, ifname, int(l.mtuInt))
+	var tunDev *wgtun.NativeTun
+	var err error
+	if cfg.Helper != nil {
+		tunDev, err = cfg.Helper.OpenTun(ifname, int(l.mtuInt), l.localIPv4, l.serverIPv4, profile(cfg))
+	} else {
+		tunDev, err = tun.OpenTunDevice(local, gw, ifname, int(l.mtuInt))
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create an interface: %s", err)
 	}
-	l.name, err = tunDev.Name()
+	name, err := tunDev.Name()
 	if err != nil {
 		if e := tunDev.Close(); e != nil {
 			log.Printf("error closing interface: %v", e)
 		}
 		return fmt.Errorf("failed to get an interface name: %s", err)
 	}
+	l.setName(name)
 
 	log.Printf("Created %s interface", l.name)
 	l.iface = &tun.Tunnel{NativeTun: tunDev}
 
+	if cfg.Netns != "" {
+		l.netns, err = openNamespace(cfg.Netns)
+		if err != nil {
+			return err
+		}
+		err = l.netns.moveLink(l.name, local, gw)
+		if err != nil {
+			return err
+		}
+		log.Printf("Moved %s interface into %q network namespace", l.name, cfg.Netns)
+	}
+
 	// can now process the traffic
 	close(l.tunUp)
 
 	return nil
 }
 
-func (l *vpnLink) configureDNS(cfg *config.Config) error {
+func (l *Link) configureDNS(cfg *config.Config) error {
 	var err error
 	// this is used only in linux/freebsd to store /etc/resolv.conf backup
 	resolv.AppName = "gof5"
 
+	if l.netns != nil {
+		if cfg.DisableDNS {
+			return nil
+		}
+		// the namespace has no other uplinks, resolve everything through VPN
+		log.Printf("Forwarding all %q network namespace DNS requests to %q", cfg.Netns, cfg.F5Config.Object.DNS)
+		return l.netns.setResolv(cfg.F5Config.Object.DNS, cfg.F5Config.Object.DNSSuffix)
+	}
+
+	cfg.ReverseZones = nil
+	cfg.FullTunnel = l.fullTunnel(cfg)
+	if splitDNS(cfg) && !cfg.DisableDNS && !cfg.DisableReverseDNS {
+		// forward the PTR queries of the VPN addresses to the VPN DNS
+		cfg.ReverseZones = dns.ReverseZones(vpnRoutes(cfg))
+		if n := len(cfg.ReverseZones); n > maxReverseZones {
+			log.Printf("Ignoring %d reverse DNS zones, the VPN routes are too fragmented", n)
+			cfg.ReverseZones = nil
+		}
+		logging.Debugf(logging.DNS, "Reverse DNS zones: %q", cfg.ReverseZones)
+	}
+
 	dnsSuffixes := cfg.F5Config.Object.DNSSuffix
 	var dnsServers []net.IP
-	if len(cfg.DNS) == 0 {
+	if !useDNSProxy(cfg) {
 		// route everything through VPN gatewy
 		dnsServers = cfg.F5Config.Object.DNS
 	} else {
@@ -247,7 +472,7 @@ func (l *vpnLink) configureDNS(cfg *config.Config) error {
 		return nil
 	}
 
-	if len(cfg.DNS) > 0 && !l.resolvHandler.IsResolve() {
+	if useDNSProxy(cfg) && !l.resolvHandler.IsResolve() {
 		// combine local network search with VPN gateway search
 		dnsSuffixes = l.resolvHandler.GetOriginalSuffixes()
 		existingSuffixes := make(map[string]bool)
@@ -261,15 +486,28 @@ func (l *vpnLink) configureDNS(cfg *config.Config) error {
 			}
 		}
 		l.resolvHandler.SetSuffixes(dnsSuffixes)
+
+		if runtime.GOOS != "darwin" {
+			return l.configureDNSProxy(cfg, dnsSuffixes)
+		}
 	}
 
 	if l.resolvHandler.IsResolve() || runtime.GOOS == "darwin" {
 		// resolve daemon will route necessary domains through VPN gatewy
 		log.Printf("Detected systemd-resolved")
+		if cfg.FilterAAAA != "" {
+			log.Printf("Ignoring %q filterAAAA mode, AAAA filtering requires the DNS proxy", cfg.FilterAAAA)
+		}
 		l.resolvHandler.SetDNSServers(cfg.F5Config.Object.DNS)
-		if len(cfg.DNS) > 0 {
-			log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, cfg.F5Config.Object.DNS)
-			l.resolvHandler.SetDNSDomains(cfg.DNS)
+		if splitDNS(cfg) {
+			zones := vpnZones(cfg)
+			log.Printf("Forwarding %q DNS requests to %q", zones, cfg.F5Config.Object.DNS)
+			l.resolvHandler.SetDNSDomains(zones)
+			for zone, v := range cfg.DNSZones {
+				if v.Keyword != config.DNSUpstreamVPN {
+					log.Printf("Ignoring %q DNS zone, dedicated and local DNS zones require the DNS proxy", zone)
+				}
+			}
 			log.Printf("Default DNS servers: %q", l.resolvHandler.GetOriginalDNS())
 		} else {
 			// route all DNS queries via VPN
@@ -285,37 +523,210 @@ func (l *vpnLink) configureDNS(cfg *config.Config) error {
 	}
 
 	if !l.resolvHandler.IsResolve() && runtime.GOOS != "darwin" {
-		if len(cfg.DNS) == 0 {
-			log.Printf("Forwarding all DNS requests to %q", cfg.F5Config.Object.DNS)
-			return nil
+		log.Printf("Forwarding all DNS requests to %q", cfg.F5Config.Object.DNS)
+	}
+
+	return nil
+}
+
+// splitDNS returns true, when only the configured zones are forwarded to the
+// VPN or the dedicated DNS servers
+func splitDNS(cfg *config.Config) bool {
+	return len(cfg.DNS) > 0 || len(cfg.DNSZones) > 0
+}
+
+// useDNSProxy returns true, when the DNS queries should be forwarded through
+// the local DNS proxy
+func useDNSProxy(cfg *config.Config) bool {
+	return splitDNS(cfg) || cfg.FilterAAAA != ""
+}
+
+// excludedIPs returns the server and proxy addresses, which are excluded from
+// the VPN routes
+func (l *Link) excludedIPs() []net.IP {
+	return append(append(append([]net.IP{}, l.serverIPs...), l.loginIPs...), l.proxyIPs...)
+}
+
+// fullTunnel returns true, when the VPN routes contain the default route,
+// except the excluded server and proxy addresses
+func (l *Link) fullTunnel(cfg *config.Config) bool {
+	routes := &netaddr.IPSet{}
+	for _, v := range vpnRoutes(cfg) {
+		routes.InsertNet(v)
+	}
+	for _, v := range l.excludedIPs() {
+		if v := v.To4(); v != nil {
+			routes.Insert(v)
 		}
-		cfg.DNSServers = l.resolvHandler.GetOriginalDNS()
+	}
+	return routes.ContainsNet(&net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)})
+}
+
+// vpnRoutes returns the configured or the server pushed routes
+func vpnRoutes(cfg *config.Config) []*net.IPNet {
+	var routes []*net.IPNet
+	if cfg.Routes != nil {
+		routes = cfg.Routes.GetNetworks()
+	} else if cfg.F5Config.Object.Routes != nil {
+		routes = cfg.F5Config.Object.Routes.GetNetworks()
+	}
+	if cfg.F5Config.Object.Routes6 != nil {
+		routes = append(routes, cfg.F5Config.Object.Routes6.GetNetworks()...)
+	}
+	return routes
+}
+
+// vpnZones returns the zones, which are forwarded to the VPN DNS servers
+func vpnZones(cfg *config.Config) []string {
+	zones := append(append([]string(nil), cfg.DNS...), cfg.ReverseZones...)
+	for zone, v := range cfg.DNSZones {
+		if v.Keyword == config.DNSUpstreamVPN {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// configureDNSProxy points the system resolver to the local DNS proxy, which
+// forwards the configured zones to the VPN DNS servers
+func (l *Link) configureDNSProxy(cfg *config.Config, suffixes []string) error {
+	systemResolv.Lock()
+	defer systemResolv.Unlock()
+
+	if systemResolv.refs == 0 {
+		// set DNS and additionally detect original DNS servers, e.g. when NetworkManager is used
+		if err := l.resolvHandler.Set(); err != nil {
+			return err
+		}
+		systemResolv.handler = l.resolvHandler
+		systemResolv.suffixes = suffixes
 		log.Printf("Serving DNS proxy on %s:53", cfg.ListenDNS)
-		log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, cfg.F5Config.Object.DNS)
-		log.Printf("Default DNS servers: %q", cfg.DNSServers)
-		dns.Start(cfg, l.ErrChan, l.TunDown)
+	} else {
+		// the system resolver already points to the DNS proxy, add the
+		// connection search suffixes
+		l.resolvHandler = systemResolv.handler
+		var added bool
+		for _, v := range cfg.F5Config.Object.DNSSuffix {
+			if !util.StrSliceContains(systemResolv.suffixes, v) {
+				systemResolv.suffixes = append(systemResolv.suffixes, v)
+				added = true
+			}
+		}
+		if added {
+			l.resolvHandler.SetSuffixes(systemResolv.suffixes)
+			if err := l.resolvHandler.Set(); err != nil {
+				return err
+			}
+		}
 	}
+	systemResolv.refs++
+	l.sharedResolv = true
+
+	cfg.DNSServers = l.resolvHandler.GetOriginalDNS()
+	if splitDNS(cfg) {
+		log.Printf("Forwarding %q DNS requests to %q", vpnZones(cfg), cfg.F5Config.Object.DNS)
+	} else {
+		log.Printf("Forwarding all DNS requests to %q", cfg.F5Config.Object.DNS)
+	}
+	if cfg.FilterAAAA == config.FilterAAAAAll && !cfg.FullTunnel {
+		log.Printf("Filtering AAAA DNS answers, %s mode, all AAAA queries are filtered only in the full tunnel mode", cfg.FilterAAAA)
+	} else if cfg.FilterAAAA != "" {
+		log.Printf("Filtering AAAA DNS answers, %s mode", cfg.FilterAAAA)
+	}
+	for zone, v := range cfg.DNSZones {
+		if len(v.Servers) > 0 {
+			log.Printf("Forwarding %q DNS requests to %q", zone, v.Servers)
+		}
+	}
+	log.Printf("Default DNS servers: %q", cfg.DNSServers)
+	dns.Start(cfg, l.ErrChan)
 
 	return nil
 }
 
+// restoreDNS restores the DNS settings, the shared system resolver
+// configuration is restored by the last connection
+func (l *Link) restoreDNS(cfg *config.Config) {
+	if !l.sharedResolv {
+		log.Printf("Restoring DNS settings")
+		l.resolvHandler.Restore()
+		return
+	}
+
+	dns.Stop(cfg)
+
+	systemResolv.Lock()
+	defer systemResolv.Unlock()
+
+	systemResolv.refs--
+	if systemResolv.refs == 0 {
+		log.Printf("Restoring DNS settings")
+		systemResolv.handler.Restore()
+		systemResolv.handler = nil
+		systemResolv.suffixes = nil
+	}
+}
+
 // wait for pppd and config DNS and routes
-func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
+func (l *Link) WaitAndConfig(cfg *config.Config) {
 	// wait for ppp handshake completed
 	<-l.pppUp
 
+	if err := l.configure(cfg); err != nil {
+		l.ErrChan <- err
+		return
+	}
+
+	l.stats.connectedAt.Store(time.Now().UnixNano())
+	log.Print(logging.Highlight("Connection established"))
+	if _, err := sdnotify.Notify(sdnotify.Ready, sdnotify.Status("Connection established on %s", l.Info().Interface)); err != nil {
+		log.Printf("Failed to notify systemd: %s", err)
+	}
+
+	// the link is unlocked, hooks may query the link status
+	if err := l.runHooks(cfg, hookPostUp, cfg.Hooks.PostUp, l.Info()); err != nil {
+		select {
+		case l.ErrChan <- err:
+		case <-l.TunDown:
+		}
+		return
+	}
+
+	if cfg.Sandbox {
+		l.Lock()
+		rules := l.sandboxRules(cfg)
+		l.Unlock()
+		if err := sandbox.Apply(rules); err != nil {
+			select {
+			case l.ErrChan <- fmt.Errorf("failed to apply sandbox: %s", err):
+			case <-l.TunDown:
+			}
+			return
+		}
+		log.Printf("Applied seccomp and Landlock sandbox")
+	}
+}
+
+// configure creates the TUN interface, configures DNS and routes
+func (l *Link) configure(cfg *config.Config) (err error) {
 	l.Lock()
 	defer l.Unlock()
+	defer l.publishInfo()
 
-	var err error
+	if cfg.NetConfig == config.NetConfigVPNCScript {
+		err = l.runVPNCScript(cfg, vpncPreInit, l.info(), nil)
+		if err != nil {
+			return err
+		}
+	}
 
 	if cfg.Driver != "pppd" {
 		// create TUN
-		err = l.createTunDevice()
+		err = l.createTunDevice(cfg)
 		if err != nil {
-			l.ErrChan <- err
-			return
+			return err
 		}
+		l.publishInfo()
 		defer func() {
 			if err != nil && l.iface != nil {
 				// destroy interface on error
@@ -326,10 +737,34 @@ func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 		}()
 	}
 
-	err = l.configureDNS(cfg)
+	// down hooks are executed, even when preUp hooks fail
+	l.hooksUp.Store(true)
+	err = l.runHooks(cfg, hookPreUp, cfg.Hooks.PreUp, l.info())
 	if err != nil {
-		l.ErrChan <- err
-		return
+		return err
+	}
+
+	if cfg.NetConfig == config.NetConfigVPNCScript {
+		return l.vpncConnect(cfg)
+	}
+
+	if splitDNS(cfg) {
+		// the server may push the host entries outside the VPN zones
+		cfg.F5Config.Object.Hosts = zoneHosts(cfg)
+	}
+
+	if cfg.Helper != nil {
+		err = l.configureHelperDNS(cfg)
+	} else {
+		err = l.configureDNS(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = l.addHosts(cfg)
+	if err != nil {
+		return err
 	}
 
 	// set routes
@@ -342,8 +777,8 @@ func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 		routes = cfg.F5Config.Object.Routes
 	}
 
-	// exclude F5 gateway IPs
-	for _, dst := range l.serverIPs {
+	// exclude F5 gateway and proxy IPs
+	for _, dst := range l.excludedIPs() {
 		// exclude only ipv4
 		if v := dst.To4(); v != nil {
 			local := &net.IPNet{
@@ -355,12 +790,15 @@ func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 	}
 
 	// exclude local DNS servers, when they are not located inside the LAN
-	for _, v := range l.resolvHandler.GetOriginalDNS() {
-		localDNS := &net.IPNet{
+	localDNS := cfg.DNSServers
+	if l.resolvHandler != nil {
+		localDNS = l.resolvHandler.GetOriginalDNS()
+	}
+	for _, v := range localDNS {
+		routes.RemoveNet(&net.IPNet{
 			IP:   v,
 			Mask: net.CIDRMask(32, 32),
-		}
-		routes.RemoveNet(localDNS)
+		})
 	}
 
 	var gw net.IP
@@ -369,21 +807,96 @@ func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 		gw = l.serverIPv4
 	}
 
-	l.routeHandler, err = route.New(l.name, routes.GetNetworks(), gw, 0)
+	var handler routeManager
+	if l.netns != nil {
+		handler, err = l.netns.newRouteHandler(l.name, routes.GetNetworks())
+	} else if cfg.Helper != nil {
+		handler = &helperRoutes{
+			helper: cfg.Helper,
+			name:   l.name,
+			routes: routes.GetNetworks(),
+		}
+	} else if cfg.PolicyRouting.Table != 0 {
+		log.Printf("Using %d routing table with %d rule priority", cfg.PolicyRouting.Table, cfg.PolicyRouting.Priority)
+		handler, err = newPolicyRouteHandler(l.name, routes.GetNetworks(), cfg.PolicyRouting)
+	} else {
+		handler, err = route.New(l.name, routes.GetNetworks(), gw, 0)
+	}
 	if err != nil {
-		l.ErrChan <- err
-		return
+		return err
 	}
+	l.routeHandler = handler
 	l.routeHandler.Add()
+	l.routes = routes.GetNetworks()
+	for _, v := range l.routes {
+		logging.Debugf(logging.Routes, "Route %s via %s", v, l.name)
+	}
 
-	colorlog.Print(color.HiGreenString("Connection established"))
+	return nil
+}
+
+// Info returns the link interface, addresses and routes
+func (l *Link) Info() Info {
+	l.infoLock.Lock()
+	defer l.infoLock.Unlock()
+
+	return l.infoSnap
+}
+
+// publishInfo updates the link info snapshot, the link must be locked
+func (l *Link) publishInfo() {
+	info := l.info()
+
+	l.infoLock.Lock()
+	defer l.infoLock.Unlock()
+
+	l.infoSnap = info
+}
+
+func (l *Link) info() Info {
+	return Info{
+		Interface:  l.name,
+		LocalIPv4:  l.localIPv4,
+		ServerIPv4: l.serverIPv4,
+		LocalIPv6:  l.localIPv6,
+		ServerIPv6: l.serverIPv6,
+		Routes:     l.routes,
+	}
 }
 
 // restore config
-func (l *vpnLink) RestoreConfig(cfg *config.Config) {
+func (l *Link) RestoreConfig(cfg *config.Config) {
+	hooksUp := l.hooksUp.Load()
+	// wait for the configuration to finish
+	l.Lock()
+	info := l.info()
+	l.Unlock()
+	if hooksUp {
+		if err := l.runHooks(cfg, hookPreDown, cfg.Hooks.PreDown, info); err != nil {
+			log.Print(err)
+		}
+	}
+
+	l.restore(cfg)
+
+	if hooksUp {
+		if err := l.runHooks(cfg, hookPostDown, cfg.Hooks.PostDown, info); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// restore removes routes, restores DNS and closes the interface
+func (l *Link) restore(cfg *config.Config) {
 	l.Lock()
 	defer l.Unlock()
 
+	if l.vpncConnected {
+		if err := l.runVPNCScript(cfg, vpncDisconnect, l.info(), l.routes); err != nil {
+			log.Print(err)
+		}
+	}
+
 	if l.routeHandler != nil {
 		log.Printf("Removing routes from %s interface", l.name)
 		l.routeHandler.Del()
@@ -391,8 +904,16 @@ func (l *vpnLink) RestoreConfig(cfg *config.Config) {
 
 	if !cfg.DisableDNS {
 		if l.resolvHandler != nil {
-			log.Printf("Restoring DNS settings")
-			l.resolvHandler.Restore()
+			l.restoreDNS(cfg)
+		}
+	}
+
+	l.removeHosts(cfg)
+
+	if l.helperDNS {
+		log.Printf("Restoring DNS settings")
+		if err := cfg.Helper.RestoreDNS(l.name); err != nil {
+			log.Print(err)
 		}
 	}
 
@@ -404,4 +925,26 @@ func (l *vpnLink) RestoreConfig(cfg *config.Config) {
 			}
 		}
 	}
+
+	if cfg.Helper != nil && l.name != "" {
+		// the helper restores the leftovers
+		if err := cfg.Helper.Close(l.name); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if l.netns != nil {
+		l.netns.restore()
+	}
+
+	if l.pcap != nil {
+		for _, v := range []io.Closer{l.pcapHTTP, l.pcapPPPD} {
+			if v != nil {
+				v.Close()
+			}
+		}
+		if err := l.pcap.Close(); err != nil {
+			log.Printf("error closing capture file: %v", err)
+		}
+	}
 }
@@ -0,0 +1,235 @@
+package link
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func TestTunnelEndpoint(t *testing.T) {
+	for _, v := range []struct {
+		server string
+		object config.Object
+		host   string
+		port   string
+	}{
+		{"vpn.example.com", config.Object{}, "vpn.example.com", "443"},
+		{"vpn.example.com:8443", config.Object{}, "vpn.example.com", "8443"},
+		{"[2001:db8::1]:8443", config.Object{}, "2001:db8::1", "8443"},
+		{"vpn.example.com:8443", config.Object{Host: "portal.example.com", Port: "443"}, "vpn.example.com", "8443"},
+		{"vpn.example.com:8443", config.Object{TunnelPort: "443"}, "vpn.example.com", "443"},
+		{"vpn.example.com", config.Object{Host: "portal.example.com", TunnelHost: "tunnel.example.com", TunnelPort: "4433"}, "tunnel.example.com", "4433"},
+		{"vpn.example.com", config.Object{TunnelHost: "tunnel.example.com:10443"}, "tunnel.example.com", "10443"},
+	} {
+		host, port := tunnelEndpoint(v.server, &v.object)
+		if host != v.host || port != v.port {
+			t.Errorf("%q: expected %s:%s, got %s:%s", v.server, v.host, v.port, host, port)
+		}
+	}
+}
+
+func TestSortIPs(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+		net.ParseIP("192.0.2.3"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+	}
+
+	for _, v := range []struct {
+		preferred net.IP
+		expected  string
+	}{
+		{nil, "[2001:db8::1 192.0.2.1 2001:db8::2 192.0.2.2 192.0.2.3]"},
+		{net.ParseIP("192.0.2.3"), "[192.0.2.3 2001:db8::1 192.0.2.1 2001:db8::2 192.0.2.2]"},
+		{net.ParseIP("2001:db8::2"), "[2001:db8::2 192.0.2.1 2001:db8::1 192.0.2.2 192.0.2.3]"},
+	} {
+		if res := fmt.Sprint(sortIPs(ips, v.preferred)); res != v.expected {
+			t.Errorf("preferred %s: expected %s, got %s", v.preferred, v.expected, res)
+		}
+	}
+}
+
+type fakeConn struct {
+	io.ReadWriter
+	addr string
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func TestDialServer(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+	}
+
+	// the first address hangs, the second one fails
+	start := time.Now()
+	conn, err := dialServer(context.Background(), "example.com", "443", ips, func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		switch addr {
+		case "[2001:db8::1]:443":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "192.0.2.1:443":
+			return nil, fmt.Errorf("connection refused")
+		}
+		return &fakeConn{addr: addr}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := conn.(*fakeConn).addr; v != "192.0.2.2:443" {
+		t.Errorf("expected 192.0.2.2:443, got %s", v)
+	}
+	if v := time.Since(start); v > dialTimeout/2 {
+		t.Errorf("expected the hanging address to be raced, took %s", v)
+	}
+
+	_, err = dialServer(context.Background(), "example.com", "443", ips, func(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+		return nil, fmt.Errorf("connection refused")
+	})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	lastServerIP.Delete("example.com")
+}
+
+func TestHookEnv(t *testing.T) {
+	_, route, _ := net.ParseCIDR("10.0.0.0/8")
+	cfg := &config.Config{
+		Name: "corp",
+		F5Config: &config.Favorite{
+			Object: config.Object{
+				DNS:       []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+				DNSSuffix: []string{"corp.example.com"},
+			},
+		},
+	}
+	info := Info{
+		Interface:  "tun0",
+		LocalIPv4:  net.ParseIP("192.168.0.2"),
+		ServerIPv4: net.ParseIP("192.168.0.1"),
+		Routes:     []*net.IPNet{route},
+	}
+
+	env := strings.Join(hookEnv(cfg, hookPostUp, info, 1400), "\n")
+	expected := strings.Join([]string{
+		"GOF5_HOOK=postUp",
+		"GOF5_CONNECTION=corp",
+		"GOF5_INTERFACE=tun0",
+		"GOF5_LOCAL_IPV4=192.168.0.2",
+		"GOF5_REMOTE_IPV4=192.168.0.1",
+		"GOF5_MTU=1400",
+		"GOF5_DNS=10.0.0.1 10.0.0.2",
+		"GOF5_DNS_SUFFIXES=corp.example.com",
+		"GOF5_ROUTES=10.0.0.0/8",
+	}, "\n")
+	if env != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, env)
+	}
+}
+
+func TestVPNCEnv(t *testing.T) {
+	_, route1, _ := net.ParseCIDR("10.0.0.0/8")
+	_, route2, _ := net.ParseCIDR("172.16.0.0/12")
+	cfg := &config.Config{
+		DNS: []string{"corp.example.com."},
+		F5Config: &config.Favorite{
+			Object: config.Object{
+				DNS:       []net.IP{net.ParseIP("10.0.0.1")},
+				DNSSuffix: []string{"corp.example.com"},
+			},
+		},
+	}
+	info := Info{
+		Interface: "tun0",
+		LocalIPv4: net.ParseIP("192.168.0.2"),
+	}
+
+	env := vpncEnv(cfg, vpncConnect, info, []*net.IPNet{route1, route2}, net.ParseIP("203.0.113.1"), 1400)
+	expected := []string{
+		"reason=connect",
+		"VPNGATEWAY=203.0.113.1",
+		"TUNDEV=tun0",
+		"INTERNAL_IP4_MTU=1400",
+		"INTERNAL_IP4_ADDRESS=192.168.0.2",
+		"INTERNAL_IP4_NETMASK=255.255.255.255",
+		"INTERNAL_IP4_DNS=10.0.0.1",
+		"CISCO_DEF_DOMAIN=corp.example.com",
+		"CISCO_SPLIT_DNS=corp.example.com",
+		"CISCO_SPLIT_INC=2",
+		"CISCO_SPLIT_INC_0_ADDR=10.0.0.0",
+		"CISCO_SPLIT_INC_0_MASK=255.0.0.0",
+		"CISCO_SPLIT_INC_0_MASKLEN=8",
+		"CISCO_SPLIT_INC_1_ADDR=172.16.0.0",
+		"CISCO_SPLIT_INC_1_MASKLEN=12",
+	}
+	got := strings.Join(env, "\n") + "\n"
+	for _, v := range expected {
+		if !strings.Contains(got, v+"\n") {
+			t.Errorf("expected %q in the environment:\n%s", v, got)
+		}
+	}
+
+	// the default route disables the split tunnel
+	_, def, _ := net.ParseCIDR("0.0.0.0/0")
+	for _, v := range vpncEnv(cfg, vpncConnect, info, []*net.IPNet{route1, def}, nil, 0) {
+		if strings.HasPrefix(v, "CISCO_SPLIT_INC") {
+			t.Errorf("unexpected %q split route", v)
+		}
+	}
+}
+
+func TestCountFrame(t *testing.T) {
+	l := &Link{TunDown: make(chan struct{})}
+	for _, v := range []struct {
+		outbound bool
+		frame    []byte
+	}{
+		{false, []byte{0x00, 0x21, 0x45, 0x00, 0x00, 0x14}},
+		{false, []byte{0x21, 0x45, 0x00}},
+		{false, []byte{0x00, 0x57, 0x60, 0x00}},
+		{true, []byte{0x00, 0x21, 0x45}},
+		// LCP echo request from pppd and the server reply
+		{true, []byte{0xc0, 0x21, 0x09, 0x07, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00}},
+		{false, []byte{0xc0, 0x21, 0x0a, 0x07, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00}},
+		{false, []byte{0x00}},
+	} {
+		t := &l.stats.rx
+		if v.outbound {
+			t = &l.stats.tx
+		}
+		l.countFrame(t, v.outbound, v.frame)
+	}
+
+	s := l.Stats()
+	if s.Rx.IPv4.Packets != 2 || s.Rx.IPv4.Bytes != 6 || s.Rx.IPv6.Packets != 1 || s.Rx.PPP.Packets != 1 {
+		t.Errorf("unexpected rx counters: %+v", s.Rx)
+	}
+	if s.Tx.IPv4.Packets != 1 || s.Tx.PPP.Packets != 1 {
+		t.Errorf("unexpected tx counters: %+v", s.Tx)
+	}
+	if s.DecodeErrors != 1 {
+		t.Errorf("expected 1 decode error, got %d", s.DecodeErrors)
+	}
+	if l.stats.echoSent.Load() == 0 || l.stats.echoPending.Load() != 0 || !l.Alive() {
+		t.Errorf("expected the echo request to be answered")
+	}
+
+	// the unanswered echo request
+	l.countFrame(&l.stats.tx, true, []byte{0xc0, 0x21, 0x09, 0x08, 0x00, 0x08})
+	l.stats.echoPending.Store(time.Now().Add(-echoTimeout).UnixNano())
+	if l.Alive() {
+		t.Errorf("expected the link without echo replies to be dead")
+	}
+}
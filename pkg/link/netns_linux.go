@@ -0,0 +1,263 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// iproute2 compatible named network namespaces location
+	netnsRunDir = "/var/run/netns"
+	// iproute2 compatible namespace specific configs location
+	netnsEtcDir = "/etc/netns"
+)
+
+// namespace holds the named network namespace state
+type namespace struct {
+	name    string
+	ns      netns.NsHandle
+	handle  *netlink.Handle
+	created bool
+	// a path to the namespace specific resolv.conf
+	resolvDir string
+	// the original namespace specific resolv.conf, nil when it didn't exist
+	resolvBackup []byte
+	resolvMode   os.FileMode
+	resolvSet    bool
+}
+
+// openNamespace opens an existing or creates a new named network namespace
+func openNamespace(name string) (*namespace, error) {
+	n := &namespace{name: name}
+
+	var err error
+	path := filepath.Join(netnsRunDir, name)
+	n.ns, err = netns.GetFromPath(path)
+	if err == nil {
+		// a stale file is left, when the namespace is removed without
+		// unmounting
+		var st unix.Statfs_t
+		if err = unix.Fstatfs(int(n.ns), &st); err != nil || st.Type != unix.NSFS_MAGIC {
+			n.close()
+			return nil, fmt.Errorf("%s is not a network namespace mount, remove the stale file", path)
+		}
+	} else {
+		n.ns, err = createNamespace(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %q network namespace: %s", name, err)
+		}
+		n.created = true
+		log.Printf("Created %q network namespace", name)
+	}
+
+	n.handle, err = netlink.NewHandleAt(n.ns)
+	if err != nil {
+		n.close()
+		return nil, fmt.Errorf("failed to open netlink handle in %q network namespace: %s", name, err)
+	}
+
+	return n, nil
+}
+
+// createNamespace creates a new network namespace and bind mounts it to the
+// path, the same way as "ip netns add" does
+func createNamespace(path string) (netns.NsHandle, error) {
+	if err := os.MkdirAll(netnsRunDir, 0755); err != nil {
+		return netns.None(), err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return netns.None(), err
+	}
+	f.Close()
+
+	// namespaces are per thread
+	runtime.LockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		os.Remove(path)
+		return netns.None(), err
+	}
+	defer orig.Close()
+
+	ns, err := netns.New()
+	if err != nil {
+		runtime.UnlockOSThread()
+		os.Remove(path)
+		return netns.None(), err
+	}
+
+	err = unix.Mount(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()), path, "none", unix.MS_BIND, "")
+
+	if e := netns.Set(orig); e != nil {
+		// don't unlock the thread, it has a wrong namespace and must be
+		// terminated by the runtime
+		log.Printf("failed to restore the original network namespace: %s", e)
+	} else {
+		runtime.UnlockOSThread()
+	}
+
+	if err != nil {
+		ns.Close()
+		os.Remove(path)
+		return netns.None(), fmt.Errorf("failed to bind mount the namespace: %s", err)
+	}
+
+	return ns, nil
+}
+
+// moveLink moves the interface into the namespace and restores its
+// configuration, which is flushed by the kernel
+func (n *namespace) moveLink(name string, local, gw *net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+
+	if err = netlink.LinkSetNsFd(link, int(n.ns)); err != nil {
+		return fmt.Errorf("failed to move %s interface into %q network namespace: %s", name, n.name, err)
+	}
+
+	link, err = n.handle.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface in %q network namespace: %s", name, n.name, err)
+	}
+
+	addr := &netlink.Addr{
+		IPNet: local,
+		Peer:  gw,
+	}
+	if err = n.handle.AddrAdd(link, addr); err != nil {
+		return fmt.Errorf("failed to set peer address on %s interface: %s", name, err)
+	}
+
+	if err = n.handle.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set %s interface up: %s", name, err)
+	}
+
+	// loopback is down in a new namespace
+	if lo, err := n.handle.LinkByName("lo"); err == nil {
+		if err = n.handle.LinkSetUp(lo); err != nil {
+			log.Printf("failed to set loopback interface up in %q network namespace: %s", n.name, err)
+		}
+	}
+
+	return nil
+}
+
+// newRouteHandler returns a handler, which adds routes into the main routing
+// table of the namespace
+func (n *namespace) newRouteHandler(name string, routes []*net.IPNet) (routeManager, error) {
+	link, err := n.handle.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect %s interface in %q network namespace: %s", name, n.name, err)
+	}
+
+	return &netlinkRouteHandler{
+		handle: n.handle,
+		link:   link,
+		routes: routes,
+		table:  unix.RT_TABLE_MAIN,
+	}, nil
+}
+
+// setResolv writes a namespace specific resolv.conf, which is bind mounted
+// over /etc/resolv.conf by "gof5 exec" and "ip netns exec"
+func (n *namespace) setResolv(dnsServers []net.IP, dnsSuffixes []string) error {
+	dir := filepath.Join(netnsEtcDir, n.name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %q directory: %s", dir, err)
+		}
+		n.resolvDir = dir
+	}
+
+	path := filepath.Join(dir, "resolv.conf")
+	if !n.resolvSet {
+		// keep the original file, it is restored on exit
+		n.resolvMode = 0644
+		if info, err := os.Stat(path); err == nil {
+			n.resolvMode = info.Mode()
+			if n.resolvBackup, err = ioutil.ReadFile(path); err != nil {
+				return fmt.Errorf("failed to read %s: %s", path, err)
+			}
+		}
+	}
+
+	buf := bytes.NewBufferString(fmt.Sprintf("# created by gof5 (PID %d)\n", os.Getpid()))
+	for _, v := range dnsServers {
+		fmt.Fprintf(buf, "nameserver %s\n", v)
+	}
+	if len(dnsSuffixes) > 0 {
+		fmt.Fprintf(buf, "search %s\n", strings.Join(dnsSuffixes, " "))
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), n.resolvMode); err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	n.resolvSet = true
+
+	return nil
+}
+
+// restore restores or removes the namespace specific resolv.conf and removes
+// the namespace, when it was created by gof5
+func (n *namespace) restore() {
+	path := filepath.Join(netnsEtcDir, n.name, "resolv.conf")
+	switch {
+	case !n.resolvSet:
+	case n.resolvBackup != nil:
+		if err := ioutil.WriteFile(path, n.resolvBackup, n.resolvMode); err != nil {
+			log.Printf("failed to restore %s: %s", path, err)
+		}
+	default:
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove %s: %s", path, err)
+		}
+	}
+	if n.resolvDir != "" {
+		if err := os.Remove(n.resolvDir); err != nil {
+			log.Printf("failed to remove %s: %s", n.resolvDir, err)
+		}
+	}
+
+	n.close()
+
+	if n.created {
+		log.Printf("Removing %q network namespace", n.name)
+		path := filepath.Join(netnsRunDir, n.name)
+		if err := unix.Unmount(path, unix.MNT_DETACH); err != nil {
+			log.Printf("failed to unmount %s: %s", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("failed to remove %s: %s", path, err)
+		}
+	}
+}
+
+func (n *namespace) close() {
+	if n.handle != nil {
+		n.handle.Delete()
+		n.handle = nil
+	}
+	if n.ns.IsOpen() {
+		n.ns.Close()
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"fmt"
+	"net"
+)
+
+// namespace is supported only in Linux
+type namespace struct{}
+
+func openNamespace(_ string) (*namespace, error) {
+	return nil, fmt.Errorf("network namespaces are supported only in Linux")
+}
+
+func (n *namespace) moveLink(_ string, _, _ *net.IPNet) error {
+	return fmt.Errorf("network namespaces are supported only in Linux")
+}
+
+func (n *namespace) newRouteHandler(_ string, _ []*net.IPNet) (routeManager, error) {
+	return nil, fmt.Errorf("network namespaces are supported only in Linux")
+}
+
+func (n *namespace) setResolv(_ []net.IP, _ []string) error {
+	return fmt.Errorf("network namespaces are supported only in Linux")
+}
+
+func (n *namespace) restore() {}
@@ -0,0 +1,115 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkRouteHandler adds VPN routes into a routing table of a network
+// namespace and creates optional "ip rule" entries
+type netlinkRouteHandler struct {
+	handle *netlink.Handle
+	link   netlink.Link
+	routes []*net.IPNet
+	rules  []*netlink.Rule
+	table  int
+}
+
+// newPolicyRouteHandler adds VPN routes into a dedicated routing table and
+// creates "ip rule" entries, which select this table for all the traffic
+// except the marked gof5 TLS/DTLS socket
+func newPolicyRouteHandler(name string, routes []*net.IPNet, p config.PolicyRouting) (routeManager, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+
+	h := &netlinkRouteHandler{
+		// current network namespace
+		handle: &netlink.Handle{},
+		link:   link,
+		routes: routes,
+		table:  p.Table,
+	}
+
+	if p.PreferLAN {
+		// ip rule add table main suppress_prefixlength 0 priority P-1
+		lan := netlink.NewRule()
+		lan.Table = unix.RT_TABLE_MAIN
+		lan.SuppressPrefixlen = 0
+		lan.Priority = p.Priority - 1
+		h.rules = append(h.rules, lan)
+	}
+
+	// ip rule add not fwmark M table T priority P
+	vpn := netlink.NewRule()
+	vpn.Table = p.Table
+	vpn.Mark = p.FwMark
+	vpn.Invert = true
+	vpn.Priority = p.Priority
+	h.rules = append(h.rules, vpn)
+
+	return h, nil
+}
+
+func (h *netlinkRouteHandler) route(dst *net.IPNet) *netlink.Route {
+	return &netlink.Route{
+		LinkIndex: h.link.Attrs().Index,
+		Dst:       dst,
+		Table:     h.table,
+	}
+}
+
+func (h *netlinkRouteHandler) Add() {
+	for _, dst := range h.routes {
+		if err := h.handle.RouteReplace(h.route(dst)); err != nil {
+			log.Printf("failed to add %s route to %d table: %s", dst, h.table, err)
+		}
+	}
+	for _, rule := range h.rules {
+		if err := h.handle.RuleAdd(rule); err != nil {
+			log.Printf("failed to add %d priority rule: %s", rule.Priority, err)
+		}
+	}
+}
+
+func (h *netlinkRouteHandler) Del() {
+	// remove rules first to avoid traffic leaks into the empty table
+	for i := len(h.rules) - 1; i >= 0; i-- {
+		if err := h.handle.RuleDel(h.rules[i]); err != nil {
+			log.Printf("failed to delete %d priority rule: %s", h.rules[i].Priority, err)
+		}
+	}
+	for _, dst := range h.routes {
+		if err := h.handle.RouteDel(h.route(dst)); err != nil {
+			log.Printf("failed to delete %s route from %d table: %s", dst, h.table, err)
+		}
+	}
+}
+
+// markSocket returns a net.Dialer control function, which sets the SO_MARK
+// on the gof5 TLS/DTLS socket, so it bypasses the VPN routing table
+func markSocket(mark int) func(string, string, syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, mark)
+		}); e != nil {
+			return e
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set %d socket mark: %s", mark, err)
+		}
+		return nil
+	}
+}
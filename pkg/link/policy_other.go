@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func newPolicyRouteHandler(_ string, _ []*net.IPNet, _ config.PolicyRouting) (routeManager, error) {
+	return nil, fmt.Errorf("policy routing is supported only in Linux")
+}
+
+func markSocket(_ int) func(string, string, syscall.RawConn) error {
+	return nil
+}
@@ -11,9 +11,9 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/kayrus/gof5/pkg/logging"
 	"github.com/kayrus/gof5/pkg/util"
 
-	"github.com/fatih/color"
 	"github.com/hpcloud/tail"
 	"github.com/zaninime/go-hdlc"
 	"golang.org/x/net/ipv4"
@@ -22,32 +22,94 @@ import (
 // TODO: handle "fatal read pppd: read /dev/ptmx: input/output error"
 // TODO: speed test vs native
 
-func (l *vpnLink) decodeHDLC(buf []byte, src string) {
+func (l *Link) decodeHDLC(buf []byte, src string) {
 	tmp := bytes.NewBuffer(buf)
 	frame, err := hdlc.NewDecoder(tmp).ReadFrame()
 	if err != nil {
-		log.Printf("fatal decode HDLC frame from %s: %s", src, err)
+		logging.Debugf(logging.Packets, "fatal decode HDLC frame from %s: %s", src, err)
 		return
 		/*
 			l.ErrChan <- fmt.Errorf("fatal decode HDLC frame from %s: %s", source, err)
 			return
 		*/
 	}
-	log.Printf("Decoded %t prefix HDLC frame from %s:\n%s", frame.HasAddressCtrlPrefix, src, hex.Dump(frame.Payload))
+	logging.Debugf(logging.Packets, "Decoded %t prefix HDLC frame from %s:\n%s", frame.HasAddressCtrlPrefix, src, hex.Dump(frame.Payload))
 	h, err := ipv4.ParseHeader(frame.Payload[:])
 	if err != nil {
-		log.Printf("fatal to parse TCP header from %s: %s", src, err)
+		logging.Debugf(logging.Packets, "fatal to parse TCP header from %s: %s", src, err)
 		return
 		/*
 			l.ErrChan <- fmt.Errorf("fatal to parse TCP header: %s", err)
 			return
 		*/
 	}
-	log.Printf("TCP: %s", h)
+	logging.Debugf(logging.Packets, "TCP: %s", h)
+}
+
+// hdlcStats returns a writer, which decodes the pppd HDLC-like framed stream
+// and counts the frames
+func (l *Link) hdlcStats(t *traffic, outbound bool) io.WriteCloser {
+	r, w := io.Pipe()
+	go func() {
+		dec := hdlc.NewDecoder(r)
+		for {
+			frame, err := dec.ReadFrame()
+			switch err {
+			case nil:
+				l.countFrame(t, outbound, frame.Payload)
+			case hdlc.ErrEmptyFrame:
+			case hdlc.ErrInvalidFrame:
+				l.stats.decodeErrors.Add(1)
+			default:
+				r.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// countFrame counts the PPP frame, pppd LCP echo requests and their replies
+// are used to measure the round-trip time and the link liveness
+func (l *Link) countFrame(t *traffic, outbound bool, p []byte) {
+	if len(p) == 0 {
+		l.stats.decodeErrors.Add(1)
+		return
+	}
+	// the protocol field may be compressed
+	proto, body := uint16(p[0]), p[1:]
+	if p[0]&1 == 0 {
+		if len(p) < 2 {
+			l.stats.decodeErrors.Add(1)
+			return
+		}
+		proto, body = uint16(p[0])<<8|uint16(p[1]), p[2:]
+	}
+
+	switch proto {
+	case 0x0021:
+		t.ipv4.add(len(body))
+	case 0x0057:
+		t.ipv6.add(len(body))
+	default:
+		t.ppp.add(len(p))
+		if proto != 0xc021 || len(body) < 2 {
+			return
+		}
+		switch {
+		case outbound && body[0] == echoReq[0]:
+			l.echoRequest(body[1])
+		case !outbound && body[0] == echoRep[0]:
+			l.echoReply(body[1])
+		}
+	}
 }
 
 // http->tun
-func (l *vpnLink) PppdHTTPToTun(pppd io.WriteCloser) {
+func (l *Link) PppdHTTPToTun(pppd io.WriteCloser) {
+	stats := l.hdlcStats(&l.stats.rx, false)
+	defer stats.Close()
+
 	buf := make([]byte, bufferSize)
 	for {
 		select {
@@ -61,24 +123,32 @@ func (l *vpnLink) PppdHTTPToTun(pppd io.WriteCloser) {
 				}
 				return
 			}
-			if l.debug {
+			if logging.Enabled(logging.Packets) {
 				l.decodeHDLC(buf[:rn], "http")
-				log.Printf("Read %d bytes from http:\n%s", rn, hex.Dump(buf[:rn]))
+				logging.Debugf(logging.Packets, "Read %d bytes from http:\n%s", rn, hex.Dump(buf[:rn]))
+			}
+			l.stats.rx.tunnel.bytes.Add(uint64(rn))
+			stats.Write(buf[:rn])
+			if l.pcapHTTP != nil {
+				l.pcapHTTP.Write(buf[:rn])
 			}
 			wn, err := pppd.Write(buf[:rn])
 			if err != nil {
 				l.ErrChan <- fmt.Errorf("fatal write to pppd: %s", err)
 				return
 			}
-			if l.debug {
-				log.Printf("Sent %d bytes to pppd", wn)
+			if logging.Enabled(logging.Packets) {
+				logging.Debugf(logging.Packets, "Sent %d bytes to pppd", wn)
 			}
 		}
 	}
 }
 
 // tun->http
-func (l *vpnLink) PppdTunToHTTP(pppd io.ReadCloser) {
+func (l *Link) PppdTunToHTTP(pppd io.ReadCloser) {
+	stats := l.hdlcStats(&l.stats.tx, true)
+	defer stats.Close()
+
 	buf := make([]byte, bufferSize)
 	for {
 		select {
@@ -92,24 +162,29 @@ func (l *vpnLink) PppdTunToHTTP(pppd io.ReadCloser) {
 				}
 				return
 			}
-			if l.debug {
-				log.Printf("Read %d bytes from pppd:\n%s", rn, hex.Dump(buf[:rn]))
+			if logging.Enabled(logging.Packets) {
+				logging.Debugf(logging.Packets, "Read %d bytes from pppd:\n%s", rn, hex.Dump(buf[:rn]))
 				l.decodeHDLC(buf[:rn], "pppd")
 			}
+			l.stats.tx.tunnel.bytes.Add(uint64(rn))
+			stats.Write(buf[:rn])
+			if l.pcapPPPD != nil {
+				l.pcapPPPD.Write(buf[:rn])
+			}
 			wn, err := l.HTTPConn.Write(buf[:rn])
 			if err != nil {
 				l.ErrChan <- fmt.Errorf("fatal write to http: %s", err)
 				return
 			}
-			if l.debug {
-				log.Printf("Sent %d bytes to http", wn)
+			if logging.Enabled(logging.Packets) {
+				logging.Debugf(logging.Packets, "Sent %d bytes to http", wn)
 			}
 		}
 	}
 }
 
 // monitor the the ppp/pppd child process status
-func (l *vpnLink) CatchPPPDTermination(cmd *exec.Cmd) {
+func (l *Link) CatchPPPDTermination(cmd *exec.Cmd) {
 	defer close(l.PppdErrChan)
 	if err := cmd.Wait(); err != nil {
 		l.PppdErrChan <- fmt.Errorf("%s process %v", cmd.Path, err)
@@ -118,7 +193,7 @@ func (l *vpnLink) CatchPPPDTermination(cmd *exec.Cmd) {
 }
 
 // gracefully stop the ppp/pppd child
-func (l *vpnLink) StopPPPDChild(cmd *exec.Cmd) {
+func (l *Link) StopPPPDChild(cmd *exec.Cmd) {
 	if cmd != nil && cmd.Process != nil {
 		cmd.Process.Signal(syscall.SIGTERM)
 		<-l.PppdErrChan
@@ -126,26 +201,26 @@ func (l *vpnLink) StopPPPDChild(cmd *exec.Cmd) {
 }
 
 // pppd log parser
-func (l *vpnLink) PppdLogParser(stderr io.Reader) {
+func (l *Link) PppdLogParser(stderr io.Reader) {
 	scanner := bufio.NewScanner(stderr)
 	for scanner.Scan() {
 		str := scanner.Text()
 		if strings.Contains(str, "Using interface") {
 			if v := strings.FieldsFunc(str, util.SplitFunc); len(v) > 0 {
-				l.name = v[len(v)-1]
+				l.setName(v[len(v)-1])
 			}
 		}
 		if strings.Contains(str, "remote IP address") {
 			close(l.pppUp)
 		}
-		colorlog.Print(color.HiGreenString(str))
+		log.Print(logging.Highlight(str))
 	}
 }
 
 // freebsd ppp log parser
 // TODO: talk directly via pppctl
 // /etc/ppp/ppp.conf should have `set server /var/run/ppp "" 0177`
-func (l *vpnLink) PppLogParser() {
+func (l *Link) PppLogParser() {
 	t, err := tail.TailFile("/var/log/ppp.log", tail.Config{
 		Location: &tail.SeekInfo{Offset: 0, Whence: io.SeekEnd},
 		Follow:   true,
@@ -163,12 +238,12 @@ func (l *vpnLink) PppLogParser() {
 		}
 		if strings.Contains(str, "Using interface") {
 			if v := strings.FieldsFunc(str, util.SplitFunc); len(v) > 0 {
-				l.name = v[len(v)-1]
+				l.setName(v[len(v)-1])
 			}
 		}
 		if strings.Contains(str, "IPCP: myaddr") {
 			close(l.pppUp)
 		}
-		colorlog.Print(color.HiGreenString(str))
+		log.Print(logging.Highlight(str))
 	}
 }
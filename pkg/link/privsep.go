@@ -0,0 +1,66 @@
+package link
+
+import (
+	"log"
+	"net"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/privsep"
+)
+
+// helperRoutes manages the routes through the privileged helper
+type helperRoutes struct {
+	helper *privsep.Client
+	name   string
+	routes []*net.IPNet
+}
+
+func (h *helperRoutes) Add() {
+	if err := h.helper.AddRoutes(h.name, h.routes); err != nil {
+		log.Printf("Failed to add routes: %s", err)
+	}
+}
+
+func (h *helperRoutes) Del() {
+	if err := h.helper.DelRoutes(h.name, h.routes); err != nil {
+		log.Printf("Failed to remove routes: %s", err)
+	}
+}
+
+// profile returns the server network profile, which is pinned by the helper
+func profile(cfg *config.Config) *privsep.Profile {
+	o := cfg.F5Config.Object
+	var routes []*net.IPNet
+	if o.Routes != nil {
+		routes = o.Routes.GetNetworks()
+	}
+	return privsep.NewProfile(routes, o.DNS, o.DNSSuffix)
+}
+
+// configureHelperDNS configures the system resolver through the privileged
+// helper, DNS zones are supported only with systemd-resolved
+func (l *Link) configureHelperDNS(cfg *config.Config) error {
+	if cfg.DisableDNS {
+		return nil
+	}
+
+	dns := &privsep.DNS{
+		Servers:  cfg.F5Config.Object.DNS,
+		Suffixes: cfg.F5Config.Object.DNSSuffix,
+		Domains:  cfg.DNS,
+	}
+	var err error
+	cfg.DNSServers, err = cfg.Helper.SetDNS(l.name, dns)
+	if err != nil {
+		return err
+	}
+	l.helperDNS = true
+
+	if len(cfg.DNS) > 0 {
+		log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, cfg.F5Config.Object.DNS)
+	} else {
+		log.Printf("Forwarding all DNS requests to %q", cfg.F5Config.Object.DNS)
+	}
+
+	return nil
+}
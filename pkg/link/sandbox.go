@@ -0,0 +1,54 @@
+package link
+
+import (
+	"path/filepath"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/sandbox"
+
+	"github.com/kayrus/tuncfg/resolv"
+)
+
+// sandboxRules returns the filesystem paths, which are required after the
+// tunnel is established: the reconnect and the configuration restore
+func (l *Link) sandboxRules(cfg *config.Config) []sandbox.Rule {
+	rules := []sandbox.Rule{
+		// cookies, known servers and the control socket
+		{Path: cfg.Path, Access: sandbox.AccessFull},
+		// name resolution and TLS certificates
+		{Path: resolv.ResolvPath, Access: sandbox.AccessRead},
+		{Path: "/etc/hosts", Access: sandbox.AccessRead},
+		{Path: "/etc/nsswitch.conf", Access: sandbox.AccessRead},
+		{Path: "/etc/ssl/certs", Access: sandbox.AccessRead},
+		{Path: "/etc/pki/tls/certs", Access: sandbox.AccessRead},
+		{Path: "/usr/share/ca-certificates", Access: sandbox.AccessRead},
+		{Path: "/run/systemd/resolve", Access: sandbox.AccessRead},
+	}
+
+	if cfg.Helper == nil {
+		// the interface is created again on reconnect
+		rules = append(rules, sandbox.Rule{Path: "/dev/net/tun", Access: sandbox.AccessWrite})
+
+		if !cfg.DisableDNS && l.resolvHandler != nil && !l.resolvHandler.IsResolve() {
+			// the sandbox forces the in place rewrite, renaming the file
+			// would require the write access to the whole /etc directory
+			rules = append(rules, sandbox.Rule{Path: resolv.ResolvPath, Access: sandbox.AccessWrite})
+		}
+	}
+
+	if l.hostsAdded {
+		// the host entries are removed in place
+		rules = append(rules, sandbox.Rule{Path: hostsPath(), Access: sandbox.AccessWrite})
+	}
+
+	if cfg.DNSQueryLog.File != "" {
+		rules = append(rules, sandbox.Rule{Path: cfg.DNSQueryLog.File, Access: sandbox.AccessWrite})
+	}
+
+	if cfg.Pcap != "" {
+		// rotated capture files
+		rules = append(rules, sandbox.Rule{Path: filepath.Dir(cfg.Pcap), Access: sandbox.AccessFull})
+	}
+
+	return rules
+}
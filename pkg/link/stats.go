@@ -0,0 +1,234 @@
+package link
+
+import (
+	"bytes"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/logging"
+)
+
+// echoInterval is the LCP echo request interval, used to measure the tunnel
+// round-trip time
+const echoInterval = 30 * time.Second
+
+// echoTimeout is the time without LCP echo replies, after which the link is
+// considered dead
+const echoTimeout = 2 * echoInterval
+
+// Counters holds the amount of bytes and packets
+type Counters struct {
+	Bytes   uint64 `json:"bytes"`
+	Packets uint64 `json:"packets"`
+}
+
+// Traffic holds the traffic counters of a single direction
+type Traffic struct {
+	// F5 tunnel stream, including the F5 and PPP framing
+	Tunnel Counters `json:"tunnel"`
+	// IP packets, the pppd stream is decoded to count them
+	IPv4 Counters `json:"ipv4"`
+	IPv6 Counters `json:"ipv6"`
+	// PPP control frames
+	PPP Counters `json:"ppp"`
+}
+
+// Stats holds the link statistics
+type Stats struct {
+	Interface   string    `json:"interface"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Reconnects  uint64    `json:"reconnects"`
+	Rx          Traffic   `json:"rx"`
+	Tx          Traffic   `json:"tx"`
+	// malformed F5 or PPP frames
+	DecodeErrors uint64 `json:"decodeErrors"`
+	// packets, which couldn't be written into the TUN interface
+	TunWriteErrors uint64 `json:"tunWriteErrors"`
+	// the last LCP echo round-trip time, pppd measures its own LCP echo
+	// requests
+	EchoRTT time.Duration `json:"echoRTT"`
+}
+
+type counters struct {
+	bytes   atomic.Uint64
+	packets atomic.Uint64
+}
+
+func (c *counters) add(n int) {
+	c.bytes.Add(uint64(n))
+	c.packets.Add(1)
+}
+
+func (c *counters) load() Counters {
+	return Counters{
+		Bytes:   c.bytes.Load(),
+		Packets: c.packets.Load(),
+	}
+}
+
+type traffic struct {
+	tunnel counters
+	ipv4   counters
+	ipv6   counters
+	ppp    counters
+}
+
+func (t *traffic) load() Traffic {
+	return Traffic{
+		Tunnel: t.tunnel.load(),
+		IPv4:   t.ipv4.load(),
+		IPv6:   t.ipv6.load(),
+		PPP:    t.ppp.load(),
+	}
+}
+
+// stats holds the link counters, which are updated by the link goroutines
+type stats struct {
+	// the interface name, which is read concurrently with the link setup
+	name           atomic.Value
+	rx             traffic
+	tx             traffic
+	decodeErrors   atomic.Uint64
+	tunWriteErrors atomic.Uint64
+	reconnects     atomic.Uint64
+	// unix time in nanoseconds
+	connectedAt atomic.Int64
+	// the last LCP echo request ID and unix time in nanoseconds
+	echoID   atomic.Uint32
+	echoSent atomic.Int64
+	echoRTT  atomic.Int64
+	// the first unanswered LCP echo request unix time in nanoseconds
+	echoPending atomic.Int64
+}
+
+// Stats returns the link statistics
+func (l *Link) Stats() Stats {
+	s := Stats{
+		Reconnects:     l.stats.reconnects.Load(),
+		Rx:             l.stats.rx.load(),
+		Tx:             l.stats.tx.load(),
+		DecodeErrors:   l.stats.decodeErrors.Load(),
+		TunWriteErrors: l.stats.tunWriteErrors.Load(),
+		EchoRTT:        time.Duration(l.stats.echoRTT.Load()),
+	}
+	if v, ok := l.stats.name.Load().(string); ok {
+		s.Interface = v
+	}
+	if v := l.stats.connectedAt.Load(); v != 0 {
+		s.ConnectedAt = time.Unix(0, v)
+	}
+	return s
+}
+
+// setName sets the interface name, which is also read by Stats
+func (l *Link) setName(name string) {
+	l.name = name
+	l.stats.name.Store(name)
+}
+
+// SetReconnects sets the amount of the link reconnects
+func (l *Link) SetReconnects(n uint64) {
+	l.stats.reconnects.Store(n)
+}
+
+// Alive reports whether the link is up and the LCP echo requests are answered
+func (l *Link) Alive() bool {
+	select {
+	case <-l.TunDown:
+		return false
+	default:
+	}
+
+	pending := l.stats.echoPending.Load()
+	return pending == 0 || time.Since(time.Unix(0, pending)) < echoTimeout
+}
+
+// LogStats periodically logs the link statistics summary
+func (l *Link) LogStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.TunDown:
+			return
+		case <-ticker.C:
+			s := l.Stats()
+			var uptime time.Duration
+			if !s.ConnectedAt.IsZero() {
+				uptime = time.Since(s.ConnectedAt).Round(time.Second)
+			}
+			log.Printf("Stats: uptime %s, rx %d bytes (ipv4 %d, ipv6 %d, ppp %d packets), tx %d bytes (ipv4 %d, ipv6 %d, ppp %d packets), decode errors %d, tun write errors %d, echo rtt %s",
+				uptime,
+				s.Rx.Tunnel.Bytes, s.Rx.IPv4.Packets, s.Rx.IPv6.Packets, s.Rx.PPP.Packets,
+				s.Tx.Tunnel.Bytes, s.Tx.IPv4.Packets, s.Tx.IPv6.Packets, s.Tx.PPP.Packets,
+				s.DecodeErrors, s.TunWriteErrors, s.EchoRTT,
+			)
+		}
+	}
+}
+
+// LCPEcho periodically sends LCP echo requests to measure the tunnel
+// round-trip time
+func (l *Link) LCPEcho() {
+	select {
+	case <-l.TunDown:
+		return
+	case <-l.tunUp:
+	}
+
+	ticker := time.NewTicker(echoInterval)
+	defer ticker.Stop()
+	dstBuf := &bytes.Buffer{}
+	for id := byte(1); ; id++ {
+		select {
+		case <-l.TunDown:
+			return
+		case <-ticker.C:
+		}
+
+		req := &bytes.Buffer{}
+		req.Write(ppp)
+		req.Write(pppLCP)
+		//
+		req.Write(echoReq)
+		req.WriteByte(id)
+		// length
+		req.Write([]byte{0x00, 0x08})
+		// the magic number is rejected during the LCP negotiation
+		req.Write(make([]byte, magicSize))
+
+		l.echoRequest(id)
+		if err := toF5(l, req.Bytes(), dstBuf); err != nil {
+			select {
+			case l.ErrChan <- err:
+			case <-l.TunDown:
+			}
+			return
+		}
+	}
+}
+
+// echoRequest records the LCP echo request, which is answered by echoReply
+func (l *Link) echoRequest(id byte) {
+	now := time.Now().UnixNano()
+	l.stats.echoID.Store(uint32(id))
+	l.stats.echoSent.Store(now)
+	l.stats.echoPending.CompareAndSwap(0, now)
+}
+
+// echoReply calculates the round-trip time of the LCP echo request
+func (l *Link) echoReply(id byte) {
+	// any reply proves the link is alive
+	l.stats.echoPending.Store(0)
+
+	sent := l.stats.echoSent.Load()
+	if sent == 0 || uint32(id) != l.stats.echoID.Load() {
+		return
+	}
+	rtt := time.Now().UnixNano() - sent
+	l.stats.echoRTT.Store(rtt)
+	if logging.Enabled(logging.PPP) {
+		logging.Debugf(logging.PPP, "id: %d, echo reply, rtt %s", id, time.Duration(rtt))
+	}
+}
@@ -0,0 +1,157 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// vpnc-script reasons
+const (
+	vpncPreInit    = "pre-init"
+	vpncConnect    = "connect"
+	vpncDisconnect = "disconnect"
+)
+
+const vpncScriptTimeout = time.Minute
+
+// runVPNCScript executes the vpnc-script with the reason and the connection
+// environment
+func (l *Link) runVPNCScript(cfg *config.Config, reason string, info Info, routes []*net.IPNet) error {
+	log.Printf("Executing %s with %s reason", cfg.VPNCScript, reason)
+	env := append(os.Environ(), vpncEnv(cfg, reason, info, routes, l.gateway(), l.mtuInt)...)
+	if err := runCommand("vpnc-script", env, vpncScriptTimeout, cfg.VPNCScript); err != nil {
+		return fmt.Errorf("vpnc-script %s failed: %s", reason, err)
+	}
+
+	return nil
+}
+
+// vpncConnect configures the interface, routes and DNS using the vpnc-script
+func (l *Link) vpncConnect(cfg *config.Config) error {
+	routes := cfg.Routes
+	if routes == nil {
+		log.Printf("Applying routes, pushed from F5 VPN server")
+		routes = cfg.F5Config.Object.Routes
+	}
+
+	if err := l.runVPNCScript(cfg, vpncConnect, l.info(), routes.GetNetworks()); err != nil {
+		return err
+	}
+	l.vpncConnected = true
+	l.routes = routes.GetNetworks()
+
+	return nil
+}
+
+// gateway returns the address, which is used to reach the VPN server
+func (l *Link) gateway() net.IP {
+	if len(l.proxyIPs) > 0 {
+		return l.proxyIPs[0]
+	}
+	if c, ok := l.HTTPConn.(interface{ RemoteAddr() net.Addr }); ok {
+		if host, _, err := net.SplitHostPort(c.RemoteAddr().String()); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				return ip
+			}
+		}
+	}
+	if len(l.serverIPs) > 0 {
+		return l.serverIPs[0]
+	}
+	return nil
+}
+
+// vpncEnv returns the vpnc-script environment variables, see
+// https://gitlab.com/openconnect/vpnc-scripts
+func vpncEnv(cfg *config.Config, reason string, info Info, routes []*net.IPNet, gateway net.IP, mtu uint16) []string {
+	env := []string{
+		"reason=" + reason,
+		"VPNPID=" + strconv.Itoa(os.Getpid()),
+	}
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	add("VPNGATEWAY", ipString(gateway))
+	add("TUNDEV", info.Interface)
+	if mtu > 0 {
+		add("INTERNAL_IP4_MTU", strconv.Itoa(int(mtu)))
+	}
+	if info.LocalIPv4 != nil {
+		add("INTERNAL_IP4_ADDRESS", info.LocalIPv4.String())
+		add("INTERNAL_IP4_NETMASK", "255.255.255.255")
+	}
+	if info.LocalIPv6 != nil {
+		add("INTERNAL_IP6_ADDRESS", info.LocalIPv6.String())
+	}
+
+	if !cfg.DisableDNS && cfg.F5Config != nil {
+		var dns4, dns6 []string
+		for _, v := range cfg.F5Config.Object.DNS {
+			if v.To4() != nil {
+				dns4 = append(dns4, v.String())
+			} else {
+				dns6 = append(dns6, v.String())
+			}
+		}
+		add("INTERNAL_IP4_DNS", strings.Join(dns4, " "))
+		add("INTERNAL_IP6_DNS", strings.Join(dns6, " "))
+		add("CISCO_DEF_DOMAIN", strings.Join(cfg.F5Config.Object.DNSSuffix, " "))
+
+		var zones []string
+		for _, v := range cfg.DNS {
+			if v = strings.Trim(v, "."); v != "" {
+				zones = append(zones, v)
+			}
+		}
+		add("CISCO_SPLIT_DNS", strings.Join(zones, ","))
+	}
+
+	var split4, split6 []*net.IPNet
+	for _, v := range routes {
+		if ones, _ := v.Mask.Size(); ones == 0 {
+			// the script sets the default route, when there are no split
+			// routes
+			split4, split6 = nil, nil
+			break
+		}
+		if v.IP.To4() != nil {
+			split4 = append(split4, v)
+		} else {
+			split6 = append(split6, v)
+		}
+	}
+	if len(split4) > 0 {
+		add("CISCO_SPLIT_INC", strconv.Itoa(len(split4)))
+		for i, v := range split4 {
+			ones, _ := v.Mask.Size()
+			prefix := fmt.Sprintf("CISCO_SPLIT_INC_%d_", i)
+			add(prefix+"ADDR", v.IP.String())
+			add(prefix+"MASK", net.IP(v.Mask).String())
+			add(prefix+"MASKLEN", strconv.Itoa(ones))
+			add(prefix+"PROTOCOL", "0")
+			add(prefix+"SPORT", "0")
+			add(prefix+"DPORT", "0")
+		}
+	}
+	if len(split6) > 0 {
+		add("CISCO_IPV6_SPLIT_INC", strconv.Itoa(len(split6)))
+		for i, v := range split6 {
+			ones, _ := v.Mask.Size()
+			prefix := fmt.Sprintf("CISCO_IPV6_SPLIT_INC_%d_", i)
+			add(prefix+"ADDR", v.IP.String())
+			add(prefix+"MASKLEN", strconv.Itoa(ones))
+		}
+	}
+
+	return env
+}
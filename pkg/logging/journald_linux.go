@@ -0,0 +1,142 @@
+//go:build linux
+// +build linux
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+)
+
+// journalSocket is the systemd journal native protocol socket
+const journalSocket = "/run/systemd/journal/socket"
+
+// syslogIdentifier is the journal SYSLOG_IDENTIFIER field value
+const syslogIdentifier = "gof5"
+
+// journaldHandler sends the records to the systemd journal using the native
+// protocol
+type journaldHandler struct {
+	mu     *sync.Mutex
+	conn   *net.UnixConn
+	prefix string
+	// preformatted fields
+	fields []byte
+}
+
+func newJournaldHandler() (slog.Handler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the journald socket: %s", err)
+	}
+	return &journaldHandler{
+		mu:   &sync.Mutex{},
+		conn: conn,
+	}, nil
+}
+
+func (h *journaldHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	appendField(buf, "MESSAGE", r.Message)
+	appendField(buf, "PRIORITY", priority(r.Level))
+	appendField(buf, "SYSLOG_IDENTIFIER", syslogIdentifier)
+	buf.Write(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttrField(buf, h.prefix, a)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := *h
+	buf := bytes.NewBuffer(append([]byte(nil), h.fields...))
+	for _, a := range attrs {
+		appendAttrField(buf, h.prefix, a)
+	}
+	res.fields = buf.Bytes()
+	return &res
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	res := *h
+	res.prefix = h.prefix + name + "_"
+	return &res
+}
+
+// priority returns the syslog priority of the level
+func priority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	}
+	return "7"
+}
+
+func appendAttrField(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, v := range a.Value.Group() {
+			appendAttrField(buf, prefix+a.Key+"_", v)
+		}
+		return
+	}
+	appendField(buf, fieldName(prefix+a.Key), a.Value.String())
+}
+
+// fieldName converts the key into a journal field name, which consists of
+// uppercase letters, digits and underscores, and doesn't start with an
+// underscore or a digit
+func fieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "F_" + name
+	}
+	return name
+}
+
+// appendField appends the field in the journal native protocol format, multi
+// line values are length prefixed
+func appendField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+func newJournaldHandler() (slog.Handler, error) {
+	return nil, fmt.Errorf("journald log format is supported only on Linux")
+}
@@ -0,0 +1,222 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// debug subsystems
+const (
+	HTTP    = "http"
+	PPP     = "ppp"
+	Packets = "packets"
+	DNS     = "dns"
+	Routes  = "routes"
+)
+
+// Subsystems is the list of the debug subsystems
+var Subsystems = []string{HTTP, PPP, Packets, DNS, Routes}
+
+// log formats
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatJournald = "journald"
+)
+
+// SubsystemKey is the log record attribute key, which holds the subsystem
+const SubsystemKey = "subsystem"
+
+// Options holds the logging parameters
+type Options struct {
+	// debug, info, warn or error, info by default
+	Level string
+	// text, json or journald, text by default
+	Format string
+	// debug subsystems, all subsystems when the level is debug and the list
+	// is empty
+	Debug []string
+}
+
+var state struct {
+	sync.RWMutex
+	format string
+	debug  map[string]bool
+}
+
+// Setup configures the default slog logger, which is also used by the
+// standard log package
+func Setup(opts Options) error {
+	return setup(opts, os.Stderr)
+}
+
+func setup(opts Options, w io.Writer) error {
+	level := slog.LevelInfo
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return fmt.Errorf("invalid %q log level", opts.Level)
+		}
+	}
+
+	debug := make(map[string]bool)
+	for _, v := range opts.Debug {
+		if !isSubsystem(v) {
+			return fmt.Errorf("invalid %q debug subsystem, supported subsystems: %s", v, strings.Join(Subsystems, ", "))
+		}
+		debug[v] = true
+	}
+	if len(debug) == 0 && level <= slog.LevelDebug {
+		for _, v := range Subsystems {
+			debug[v] = true
+		}
+	}
+
+	var h slog.Handler
+	switch opts.Format {
+	case "", FormatText:
+		opts.Format = FormatText
+		h = newTextHandler(w)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	case FormatJournald:
+		var err error
+		h, err = newJournaldHandler()
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid %q log format, supported formats: %s, %s, %s", opts.Format, FormatText, FormatJSON, FormatJournald)
+	}
+
+	state.Lock()
+	state.format = opts.Format
+	state.debug = debug
+	state.Unlock()
+
+	slog.SetDefault(slog.New(&filterHandler{handler: h, level: level}))
+	// the standard log package messages are logged with the info level, the
+	// timestamp is added by the handler
+	log.SetFlags(0)
+
+	return nil
+}
+
+// EnableDebug enables the debug subsystems, all subsystems when the list is
+// empty
+func EnableDebug(subsystems ...string) {
+	if len(subsystems) == 0 {
+		subsystems = Subsystems
+	}
+
+	state.Lock()
+	defer state.Unlock()
+	if state.debug == nil {
+		state.debug = make(map[string]bool)
+	}
+	for _, v := range subsystems {
+		state.debug[v] = true
+	}
+}
+
+// Enabled reports whether the subsystem debug logs are enabled
+func Enabled(subsystem string) bool {
+	state.RLock()
+	defer state.RUnlock()
+	return state.debug[subsystem]
+}
+
+// Debugf logs the subsystem debug message, when the subsystem is enabled
+func Debugf(subsystem string, format string, args ...interface{}) {
+	if !Enabled(subsystem) {
+		return
+	}
+	slog.Default().Log(context.Background(), slog.LevelDebug, fmt.Sprintf(format, args...), SubsystemKey, subsystem)
+}
+
+// Highlight returns the message, which is colored in the text format
+func Highlight(msg string) string {
+	state.RLock()
+	defer state.RUnlock()
+	if state.format != "" && state.format != FormatText {
+		return msg
+	}
+	return color.HiGreenString(msg)
+}
+
+// ParseSubsystems parses the comma separated list of the debug subsystems
+func ParseSubsystems(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func isSubsystem(s string) bool {
+	for _, v := range Subsystems {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// filterHandler filters the records by the level and the debug subsystem, and
+// redacts the secrets
+type filterHandler struct {
+	handler slog.Handler
+	level   slog.Level
+}
+
+func (h *filterHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	// subsystem debug records are filtered by the Debugf
+	return level >= h.level || level == slog.LevelDebug
+}
+
+func (h *filterHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.level && !hasSubsystem(r) {
+		return nil
+	}
+
+	res := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		res.AddAttrs(redactAttr(a))
+		return true
+	})
+
+	return h.handler.Handle(ctx, res)
+}
+
+func (h *filterHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		res[i] = redactAttr(a)
+	}
+	return &filterHandler{handler: h.handler.WithAttrs(res), level: h.level}
+}
+
+func (h *filterHandler) WithGroup(name string) slog.Handler {
+	return &filterHandler{handler: h.handler.WithGroup(name), level: h.level}
+}
+
+func hasSubsystem(r slog.Record) bool {
+	var ok bool
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == SubsystemKey {
+			ok = Enabled(a.Value.String())
+			return false
+		}
+		return true
+	})
+	return ok
+}
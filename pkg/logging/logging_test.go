@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+
+	var buf bytes.Buffer
+	if err := setup(Options{Debug: []string{DNS}}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	AddSecret("0123456789abcdef")
+
+	log.Printf("Connected with 0123456789abcdef session")
+	Debugf(DNS, "Resolving %q", "example.com.")
+	Debugf(Packets, "Read %d bytes", 10)
+	slog.Debug("hidden")
+	slog.Warn("Warning", "session", "secret", "count", 2)
+
+	out := buf.String()
+	for _, v := range []string{
+		"Connected with [REDACTED] session\n",
+		`DEBUG [dns] Resolving "example.com."` + "\n",
+		"WARN Warning session=[REDACTED] count=2\n",
+	} {
+		if !strings.Contains(out, v) {
+			t.Errorf("expected %q in the output:\n%s", v, out)
+		}
+	}
+	for _, v := range []string{"INFO", "Read 10 bytes", "hidden", "0123456789abcdef"} {
+		if strings.Contains(out, v) {
+			t.Errorf("unexpected %q in the output:\n%s", v, out)
+		}
+	}
+
+	if err := setup(Options{Debug: []string{"foo"}}, &buf); err == nil {
+		t.Errorf("expected an error for an unknown subsystem")
+	}
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets in the logs
+const Redacted = "[REDACTED]"
+
+// minSecretLen prevents the redaction of short common substrings
+const minSecretLen = 8
+
+// sensitiveKeys are the log record attribute keys, which values are always
+// redacted
+var sensitiveKeys = map[string]struct{}{
+	"password":   {},
+	"token":      {},
+	"session":    {},
+	"sessionid":  {},
+	"session_id": {},
+	"mrhsession": {},
+}
+
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// AddSecret registers the value, e.g. a session ID, which is redacted in all
+// log messages
+func AddSecret(v string) {
+	if len(v) < minSecretLen {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, s := range secrets.values {
+		if s == v {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, v)
+}
+
+// redact replaces the registered secrets in the string
+func redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if _, ok := sensitiveKeys[strings.ToLower(a.Key)]; ok {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		res := make([]slog.Attr, len(attrs))
+		for i, v := range attrs {
+			res[i] = redactAttr(v)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(res...)}
+	case slog.KindString, slog.KindAny, slog.KindLogValuer:
+		return slog.String(a.Key, redact(a.Value.Resolve().String()))
+	}
+
+	return a
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// textTimeFormat is the standard log package timestamp format
+const textTimeFormat = "2006/01/02 15:04:05"
+
+// textHandler writes the records in the standard log package format, the
+// level is omitted for the info records
+type textHandler struct {
+	mu        *sync.Mutex
+	w         io.Writer
+	prefix    string
+	subsystem string
+	// preformatted attributes
+	attrs string
+}
+
+func newTextHandler(w io.Writer) *textHandler {
+	return &textHandler{
+		mu: &sync.Mutex{},
+		w:  w,
+	}
+}
+
+func (h *textHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	if !r.Time.IsZero() {
+		buf.WriteString(r.Time.Format(textTimeFormat))
+		buf.WriteByte(' ')
+	}
+	if r.Level != slog.LevelInfo {
+		buf.WriteString(r.Level.String())
+		buf.WriteByte(' ')
+	}
+
+	subsystem := h.subsystem
+	var attrs bytes.Buffer
+	attrs.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == SubsystemKey {
+			subsystem = a.Value.String()
+			return true
+		}
+		appendAttr(&attrs, h.prefix, a)
+		return true
+	})
+
+	if subsystem != "" {
+		fmt.Fprintf(buf, "[%s] ", subsystem)
+	}
+	buf.WriteString(r.Message)
+	buf.Write(attrs.Bytes())
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := *h
+	buf := bytes.NewBufferString(h.attrs)
+	for _, a := range attrs {
+		if a.Key == SubsystemKey && h.prefix == "" {
+			res.subsystem = a.Value.String()
+			continue
+		}
+		appendAttr(buf, h.prefix, a)
+	}
+	res.attrs = buf.String()
+	return &res
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	res := *h
+	res.prefix = h.prefix + name + "."
+	return &res
+}
+
+func appendAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, v := range a.Value.Group() {
+			appendAttr(buf, prefix+a.Key+".", v)
+		}
+		return
+	}
+
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " =\"\n\t") {
+		v = strconv.Quote(v)
+	}
+	fmt.Fprintf(buf, " %s%s=%s", prefix, a.Key, v)
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/control"
+	"github.com/kayrus/gof5/pkg/dns"
+	"github.com/kayrus/gof5/pkg/link"
+)
+
+// contentType is the Prometheus text exposition format content type
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const timeout = 10 * time.Second
+
+// Listen serves the Prometheus metrics of the control API connections on the
+// addr /metrics path
+func Listen(addr string, ctrl *control.Server) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %q metrics address: %s", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		write(w, ctrl.Statuses(), dns.Stats(), time.Now())
+	})
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+
+	log.Printf("Serving metrics on http://%s/metrics", l.Addr())
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Failed to serve metrics: %s", err)
+		}
+	}()
+
+	return srv, nil
+}
+
+// family is a set of samples of the same metric
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []string
+}
+
+func (f *family) add(value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(f.name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	f.samples = append(f.samples, b.String())
+}
+
+func (f *family) write(w io.Writer) {
+	if len(f.samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	sort.Strings(f.samples)
+	for _, v := range f.samples {
+		fmt.Fprintln(w, v)
+	}
+}
+
+// escapeLabel escapes the label value as defined by the Prometheus text
+// format, the other characters are written as is
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// write writes the metrics in the Prometheus text exposition format
+func write(w io.Writer, statuses []control.Status, queries []dns.QueryStats, now time.Time) {
+	up := &family{name: "gof5_tunnel_up", help: "Whether the VPN tunnel is established.", typ: "gauge"}
+	age := &family{name: "gof5_session_age_seconds", help: "Age of the VPN session in seconds.", typ: "gauge"}
+	tunnelBytes := &family{name: "gof5_tunnel_bytes_total", help: "Bytes transferred through the F5 tunnel, including the framing.", typ: "counter"}
+	packets := &family{name: "gof5_packets_total", help: "Packets transferred through the tunnel by protocol.", typ: "counter"}
+	packetBytes := &family{name: "gof5_packet_bytes_total", help: "Bytes of the packets transferred through the tunnel by protocol.", typ: "counter"}
+	decodeErrors := &family{name: "gof5_decode_errors_total", help: "Malformed F5 or PPP frames.", typ: "counter"}
+	tunWriteErrors := &family{name: "gof5_tun_write_errors_total", help: "Packets, which couldn't be written into the TUN interface.", typ: "counter"}
+	echoRTT := &family{name: "gof5_lcp_echo_rtt_seconds", help: "Last LCP echo round-trip time in seconds.", typ: "gauge"}
+	reconnects := &family{name: "gof5_reconnects_total", help: "Tunnel reconnects by reason.", typ: "counter"}
+	loginFailures := &family{name: "gof5_login_failures_total", help: "Login failures by error type.", typ: "counter"}
+	dnsQueries := &family{name: "gof5_dns_queries_total", help: "DNS proxy queries by upstream and outcome.", typ: "counter"}
+
+	for _, s := range statuses {
+		conn := s.Name
+		if conn == "" {
+			conn = s.Server
+		}
+
+		var v float64
+		if s.State == control.StateConnected {
+			v = 1
+		}
+		up.add(v, "connection", conn, "server", s.Server)
+
+		if !s.SessionStart.IsZero() {
+			age.add(now.Sub(s.SessionStart).Seconds(), "connection", conn)
+		}
+
+		if st := s.Stats; st != nil {
+			for direction, t := range map[string]link.Traffic{"rx": st.Rx, "tx": st.Tx} {
+				tunnelBytes.add(float64(t.Tunnel.Bytes), "connection", conn, "direction", direction)
+				for proto, c := range map[string]link.Counters{"ipv4": t.IPv4, "ipv6": t.IPv6, "ppp": t.PPP} {
+					packets.add(float64(c.Packets), "connection", conn, "direction", direction, "proto", proto)
+					packetBytes.add(float64(c.Bytes), "connection", conn, "direction", direction, "proto", proto)
+				}
+			}
+			decodeErrors.add(float64(st.DecodeErrors), "connection", conn)
+			tunWriteErrors.add(float64(st.TunWriteErrors), "connection", conn)
+			if st.EchoRTT > 0 {
+				echoRTT.add(st.EchoRTT.Seconds(), "connection", conn)
+			}
+		}
+
+		for _, k := range sortedKeys(s.Reconnects) {
+			reconnects.add(float64(s.Reconnects[k]), "connection", conn, "reason", k)
+		}
+		for _, k := range sortedKeys(s.LoginFailures) {
+			loginFailures.add(float64(s.LoginFailures[k]), "connection", conn, "type", k)
+		}
+	}
+
+	for _, q := range queries {
+		dnsQueries.add(float64(q.Count), "upstream", q.Upstream, "outcome", q.Outcome)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range []*family{
+		up,
+		age,
+		tunnelBytes,
+		packets,
+		packetBytes,
+		decodeErrors,
+		tunWriteErrors,
+		echoRTT,
+		reconnects,
+		loginFailures,
+		dnsQueries,
+	} {
+		f.write(bw)
+	}
+	bw.Flush()
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/control"
+	"github.com/kayrus/gof5/pkg/dns"
+	"github.com/kayrus/gof5/pkg/link"
+)
+
+func TestWrite(t *testing.T) {
+	now := time.Now()
+	statuses := []control.Status{
+		{
+			Name:          "corp",
+			Server:        "vpn.example.com",
+			State:         control.StateConnected,
+			SessionStart:  now.Add(-90 * time.Second),
+			Reconnects:    map[string]uint64{"control": 2},
+			LoginFailures: map[string]uint64{"credentials": 1},
+			Stats: &link.Stats{
+				Rx:      link.Traffic{Tunnel: link.Counters{Bytes: 1000}, IPv4: link.Counters{Bytes: 900, Packets: 10}},
+				EchoRTT: 25 * time.Millisecond,
+			},
+		},
+		{
+			Server: "vpn2.example.com",
+			State:  control.StateConnecting,
+		},
+	}
+	queries := []dns.QueryStats{
+		{Upstream: dns.UpstreamVPN, Outcome: "noerror", Count: 3},
+	}
+
+	var buf bytes.Buffer
+	write(&buf, statuses, queries, now)
+	out := buf.String()
+
+	for _, v := range []string{
+		"# TYPE gof5_tunnel_up gauge\n",
+		`gof5_tunnel_up{connection="corp",server="vpn.example.com"} 1` + "\n",
+		`gof5_tunnel_up{connection="vpn2.example.com",server="vpn2.example.com"} 0` + "\n",
+		`gof5_session_age_seconds{connection="corp"} 90` + "\n",
+		`gof5_tunnel_bytes_total{connection="corp",direction="rx"} 1000` + "\n",
+		`gof5_packets_total{connection="corp",direction="rx",proto="ipv4"} 10` + "\n",
+		`gof5_packet_bytes_total{connection="corp",direction="rx",proto="ipv4"} 900` + "\n",
+		`gof5_lcp_echo_rtt_seconds{connection="corp"} 0.025` + "\n",
+		`gof5_reconnects_total{connection="corp",reason="control"} 2` + "\n",
+		`gof5_login_failures_total{connection="corp",type="credentials"} 1` + "\n",
+		`gof5_dns_queries_total{upstream="vpn",outcome="noerror"} 3` + "\n",
+	} {
+		if !strings.Contains(out, v) {
+			t.Errorf("expected %q in the output:\n%s", v, out)
+		}
+	}
+
+	if n := strings.Count(out, "# HELP gof5_tunnel_up "); n != 1 {
+		t.Errorf("expected a single gof5_tunnel_up HELP line, got %d", n)
+	}
+	if strings.Contains(out, `gof5_session_age_seconds{connection="vpn2.example.com"}`) {
+		t.Errorf("unexpected session age of the connection without a session:\n%s", out)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	for _, v := range []struct {
+		value    string
+		expected string
+	}{
+		{"corp", "corp"},
+		{`a\b`, `a\\b`},
+		{`say "hi"`, `say \"hi\"`},
+		{"line\nbreak", `line\nbreak`},
+		{"tab\tand ünicode", "tab\tand ünicode"},
+	} {
+		if res := escapeLabel(v.value); res != v.expected {
+			t.Errorf("%q: expected %q, got %q", v.value, v.expected, res)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/zaninime/go-hdlc"
+)
+
+// Direction of a captured packet
+type Direction uint32
+
+const (
+	Inbound  Direction = 1
+	Outbound Direction = 2
+)
+
+// pcapng link types, see https://www.tcpdump.org/linktypes.html
+const (
+	LinkTypePPP = 9
+	LinkTypeRaw = 101
+)
+
+// pcapng block types and options
+const (
+	blockSHB = 0x0a0d0d0a
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+
+	optEndOfOpt    = 0
+	optShbUserAppl = 4
+	optIfName      = 2
+	optEpbFlags    = 2
+)
+
+// interface IDs in the section
+const (
+	ifaceIP = iota
+	ifacePPP
+)
+
+var order = binary.LittleEndian
+
+// Writer writes decoded tunnel packets and PPP control frames into a
+// pcapng file with optional rotation
+type Writer struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	uid      int
+	gid      int
+	file     *os.File
+	size     int64
+	failed   bool
+}
+
+// New creates a pcapng file. When maxSize is greater than zero, the file is
+// rotated after reaching maxSize bytes, keeping up to maxFiles old files.
+func New(path string, maxSize int64, maxFiles int, uid, gid int) (*Writer, error) {
+	w := &Writer{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		uid:      uid,
+		gid:      gid,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %q capture file: %s", w.path, err)
+	}
+	// windows preserves the original user parameters, no need to chown
+	if runtime.GOOS != "windows" {
+		if err := f.Chown(w.uid, w.gid); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to set an owner for the %q capture file: %s", w.path, err)
+		}
+	}
+	w.file = f
+	w.size = 0
+
+	// section header and two interfaces: raw IP and PPP control frames
+	buf := &bytes.Buffer{}
+	body := &bytes.Buffer{}
+	binary.Write(body, order, uint32(byteOrderMagic))
+	binary.Write(body, order, uint16(1)) // major version
+	binary.Write(body, order, uint16(0)) // minor version
+	binary.Write(body, order, int64(-1)) // unknown section length
+	writeOption(body, optShbUserAppl, []byte("gof5"))
+	writeOption(body, optEndOfOpt, nil)
+	writeBlock(buf, blockSHB, body.Bytes())
+
+	for _, v := range []struct {
+		linkType uint16
+		name     string
+	}{
+		{LinkTypeRaw, "ip"},
+		{LinkTypePPP, "ppp"},
+	} {
+		body.Reset()
+		binary.Write(body, order, v.linkType)
+		binary.Write(body, order, uint16(0)) // reserved
+		binary.Write(body, order, uint32(0)) // no snap length limit
+		writeOption(body, optIfName, []byte(v.name))
+		writeOption(body, optEndOfOpt, nil)
+		writeBlock(buf, blockIDB, body.Bytes())
+	}
+
+	return w.write(buf.Bytes())
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return err
+}
+
+// rotate renames the current file to path.1, path.1 to path.2 and so on
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if w.maxFiles > 0 {
+		for i := w.maxFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	}
+	return w.open()
+}
+
+func (w *Writer) writePacket(iface uint32, dir Direction, data []byte) {
+	if w == nil || len(data) == 0 {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.failed {
+		return
+	}
+
+	ts := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	body := &bytes.Buffer{}
+	binary.Write(body, order, iface)
+	binary.Write(body, order, uint32(ts>>32))
+	binary.Write(body, order, uint32(ts))
+	binary.Write(body, order, uint32(len(data))) // captured length
+	binary.Write(body, order, uint32(len(data))) // original length
+	body.Write(data)
+	body.Write(make([]byte, pad(len(data))))
+	flags := make([]byte, 4)
+	order.PutUint32(flags, uint32(dir))
+	writeOption(body, optEpbFlags, flags)
+	writeOption(body, optEndOfOpt, nil)
+
+	buf := &bytes.Buffer{}
+	writeBlock(buf, blockEPB, body.Bytes())
+
+	var err error
+	if w.maxSize > 0 && w.size+int64(buf.Len()) > w.maxSize {
+		err = w.rotate()
+	}
+	if err == nil {
+		err = w.write(buf.Bytes())
+	}
+	if err != nil {
+		// don't break the tunnel because of the capture failure
+		log.Printf("Failed to write %q capture file, capture is stopped: %s", w.path, err)
+		w.failed = true
+	}
+}
+
+// WriteIP writes an IPv4 or IPv6 packet
+func (w *Writer) WriteIP(dir Direction, data []byte) {
+	w.writePacket(ifaceIP, dir, data)
+}
+
+// WritePPP writes a PPP frame, which may start with 0xff 0x03 address and
+// control fields
+func (w *Writer) WritePPP(dir Direction, data []byte) {
+	w.writePacket(ifacePPP, dir, data)
+}
+
+// HDLCTap returns a writer, which decodes an HDLC-like framed byte stream,
+// e.g. pppd data, and writes the decoded PPP frames
+func (w *Writer) HDLCTap(dir Direction) io.WriteCloser {
+	if w == nil {
+		return nil
+	}
+
+	r, pw := io.Pipe()
+	t := &hdlcTap{PipeWriter: pw, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		dec := hdlc.NewDecoder(r)
+		for {
+			frame, err := dec.ReadFrame()
+			if err == hdlc.ErrEmptyFrame || err == hdlc.ErrInvalidFrame {
+				continue
+			}
+			if err != nil {
+				r.CloseWithError(err)
+				return
+			}
+			w.writeFrame(dir, frame)
+		}
+	}()
+
+	return t
+}
+
+// hdlcTap waits for the decoder to write the pending frames on close
+type hdlcTap struct {
+	*io.PipeWriter
+	done chan struct{}
+}
+
+func (t *hdlcTap) Close() error {
+	err := t.PipeWriter.Close()
+	<-t.done
+	return err
+}
+
+// writeFrame writes IP packets as raw IP and the rest as PPP frames
+func (w *Writer) writeFrame(dir Direction, frame *hdlc.Frame) {
+	p := frame.Payload
+	switch {
+	case len(p) > 1 && (p[0] == 0x21 || p[0] == 0x57):
+		// compressed protocol field
+		w.WriteIP(dir, p[1:])
+	case len(p) > 2 && p[0] == 0x00 && (p[1] == 0x21 || p[1] == 0x57):
+		w.WriteIP(dir, p[2:])
+	case frame.HasAddressCtrlPrefix:
+		w.WritePPP(dir, append([]byte{0xff, 0x03}, p...))
+	default:
+		w.WritePPP(dir, p)
+	}
+}
+
+// Close closes the capture file
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.failed = true
+	return w.file.Close()
+}
+
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+func writeOption(buf *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(buf, order, code)
+	binary.Write(buf, order, uint16(len(value)))
+	buf.Write(value)
+	buf.Write(make([]byte, pad(len(value))))
+}
+
+func writeBlock(buf *bytes.Buffer, blockType uint32, body []byte) {
+	// block type, total length, body, total length
+	length := uint32(12 + len(body))
+	binary.Write(buf, order, blockType)
+	binary.Write(buf, order, length)
+	buf.Write(body)
+	binary.Write(buf, order, length)
+}
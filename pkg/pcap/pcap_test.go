@@ -0,0 +1,235 @@
+package pcap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zaninime/go-hdlc"
+)
+
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks reads the pcapng blocks and verifies their lengths
+func readBlocks(t *testing.T, path string) []block {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks []block
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("%d bytes left, expected a block", len(data))
+		}
+		length := int(order.Uint32(data[4:]))
+		if length%4 != 0 {
+			t.Errorf("block length %d is not padded to 32 bits", length)
+		}
+		if length < 12 || length > len(data) {
+			t.Fatalf("invalid %d block length", length)
+		}
+		if v := int(order.Uint32(data[length-4:])); v != length {
+			t.Errorf("trailing block length %d differs from %d", v, length)
+		}
+		blocks = append(blocks, block{order.Uint32(data), data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// readOptions returns the block options
+func readOptions(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+
+	opts := make(map[uint16][]byte)
+	for len(b) >= 4 {
+		code, length := order.Uint16(b), int(order.Uint16(b[2:]))
+		if code == optEndOfOpt {
+			return opts
+		}
+		if 4+length+pad(length) > len(b) {
+			t.Fatalf("invalid %d option length", length)
+		}
+		opts[code] = b[4 : 4+length]
+		b = b[4+length+pad(length):]
+	}
+	t.Errorf("options are not terminated")
+	return opts
+}
+
+type packet struct {
+	iface uint32
+	ts    time.Time
+	dir   Direction
+	data  []byte
+}
+
+// readPackets verifies the section header and the interfaces and returns the
+// enhanced packet blocks
+func readPackets(t *testing.T, path string) []packet {
+	t.Helper()
+
+	blocks := readBlocks(t, path)
+	if len(blocks) < 3 {
+		t.Fatalf("expected the section header and two interfaces, got %d blocks", len(blocks))
+	}
+
+	shb := blocks[0]
+	if shb.typ != blockSHB || order.Uint32(shb.body) != byteOrderMagic {
+		t.Errorf("invalid section header block")
+	}
+	if v := string(readOptions(t, shb.body[16:])[optShbUserAppl]); v != "gof5" {
+		t.Errorf("expected gof5 application, got %q", v)
+	}
+
+	for i, v := range []struct {
+		linkType uint16
+		name     string
+	}{
+		{LinkTypeRaw, "ip"},
+		{LinkTypePPP, "ppp"},
+	} {
+		idb := blocks[i+1]
+		if idb.typ != blockIDB || order.Uint16(idb.body) != v.linkType {
+			t.Errorf("%d: expected %d link type interface", i, v.linkType)
+		}
+		opts := readOptions(t, idb.body[8:])
+		if string(opts[optIfName]) != v.name {
+			t.Errorf("%d: expected %q interface, got %q", i, v.name, opts[optIfName])
+		}
+	}
+
+	var packets []packet
+	for _, b := range blocks[3:] {
+		if b.typ != blockEPB {
+			t.Errorf("expected enhanced packet block, got %x", b.typ)
+			continue
+		}
+		// the interfaces have no if_tsresol option, i.e. microseconds
+		ts := uint64(order.Uint32(b.body[4:]))<<32 | uint64(order.Uint32(b.body[8:]))
+		capLen, origLen := int(order.Uint32(b.body[12:])), int(order.Uint32(b.body[16:]))
+		if capLen != origLen {
+			t.Errorf("captured length %d differs from %d", capLen, origLen)
+		}
+		data := b.body[20 : 20+capLen]
+		if padding := b.body[20+capLen : 20+capLen+pad(capLen)]; !bytes.Equal(padding, make([]byte, len(padding))) {
+			t.Errorf("expected zero padding, got %x", padding)
+		}
+		opts := readOptions(t, b.body[20+capLen+pad(capLen):])
+		if len(opts[optEpbFlags]) != 4 {
+			t.Fatalf("expected flags option")
+		}
+		packets = append(packets, packet{
+			iface: order.Uint32(b.body),
+			ts:    time.UnixMicro(int64(ts)),
+			dir:   Direction(order.Uint32(opts[optEpbFlags])),
+			data:  data,
+		})
+	}
+	return packets
+}
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	w, err := New(path, 0, 0, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Truncate(time.Microsecond)
+	ip := []byte{0x45, 0x00, 0x00, 0x14, 0x01}
+	lcp := []byte{0xff, 0x03, 0xc0, 0x21}
+	w.WriteIP(Inbound, ip)
+	w.WritePPP(Outbound, lcp)
+	// empty packets are skipped
+	w.WriteIP(Inbound, nil)
+	end := time.Now()
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the closed writer doesn't write
+	w.WriteIP(Inbound, ip)
+
+	packets := readPackets(t, path)
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	for i, v := range []packet{
+		{ifaceIP, time.Time{}, Inbound, ip},
+		{ifacePPP, time.Time{}, Outbound, lcp},
+	} {
+		p := packets[i]
+		if p.iface != v.iface || p.dir != v.dir || !bytes.Equal(p.data, v.data) {
+			t.Errorf("%d: expected %d interface %d direction %x, got %d %d %x", i, v.iface, v.dir, v.data, p.iface, p.dir, p.data)
+		}
+		if p.ts.Before(start) || p.ts.After(end) {
+			t.Errorf("%d: %s timestamp is out of the %s - %s range", i, p.ts, start, end)
+		}
+	}
+}
+
+func TestHDLCTap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	w, err := New(path, 0, 0, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ip := []byte{0x45, 0x00, 0x00, 0x14, 0x7e, 0x7d}
+	lcp := []byte{0xc0, 0x21, 0x09, 0x01, 0x00, 0x08}
+	stream := &bytes.Buffer{}
+	enc := hdlc.NewEncoder(stream)
+	for _, f := range []*hdlc.Frame{
+		hdlc.Encapsulate(append([]byte{0x00, 0x21}, ip...), true),
+		hdlc.Encapsulate(append([]byte{0x21}, ip...), false),
+		hdlc.Encapsulate(lcp, true),
+		hdlc.Encapsulate(lcp, false),
+	} {
+		if _, err = enc.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tap := w.HDLCTap(Outbound)
+	// split the frames between writes
+	b := stream.Bytes()
+	for len(b) > 0 {
+		n := 5
+		if n > len(b) {
+			n = len(b)
+		}
+		if _, err = tap.Write(b[:n]); err != nil {
+			t.Fatal(err)
+		}
+		b = b[n:]
+	}
+	// close waits for the decoder to write the frames
+	if err = tap.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := readPackets(t, path)
+	if len(packets) != 4 {
+		t.Fatalf("expected 4 packets, got %d", len(packets))
+	}
+	for i, v := range []packet{
+		{iface: ifaceIP, data: ip},
+		{iface: ifaceIP, data: ip},
+		{iface: ifacePPP, data: append([]byte{0xff, 0x03}, lcp...)},
+		{iface: ifacePPP, data: lcp},
+	} {
+		p := packets[i]
+		if p.iface != v.iface || p.dir != Outbound || !bytes.Equal(p.data, v.data) {
+			t.Errorf("%d: expected %d interface %x, got %d %d %x", i, v.iface, v.data, p.iface, p.dir, p.data)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package pcap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		// verify that the files are chowned
+		uid, gid = 12345, 12346
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	// the header blocks and a single packet fit into the file
+	const header, packet = 104, 48
+	w, err := New(path, header+packet, 2, uid, gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := byte(1); i <= 4; i++ {
+		w.WriteIP(Inbound, []byte{0x45, 0, 0, i})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest packet is dropped, the files are shifted
+	for i, v := range map[string]byte{
+		path:        4,
+		path + ".1": 3,
+		path + ".2": 2,
+	} {
+		fi, err := os.Stat(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != header+packet {
+			t.Errorf("%s: expected %d bytes, got %d", i, header+packet, fi.Size())
+		}
+		if st := fi.Sys().(*syscall.Stat_t); int(st.Uid) != uid || int(st.Gid) != gid {
+			t.Errorf("%s: expected %d:%d owner, got %d:%d", i, uid, gid, st.Uid, st.Gid)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: expected 0600 mode, got %s", i, fi.Mode().Perm())
+		}
+
+		packets := readPackets(t, i)
+		if len(packets) != 1 || packets[0].data[3] != v {
+			t.Errorf("%s: expected a single %d packet", i, v)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.3", path)); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package pin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	// KnownServersName is the file name, which contains trusted on first use
+	// server public keys
+	KnownServersName = "known_servers"
+	prefix           = "sha256//"
+)
+
+// Verifier verifies server public keys against pins and known servers, in
+// addition to the regular CA and hostname verification
+type Verifier struct {
+	pins map[string]bool
+	tofu bool
+	// known servers file path
+	path string
+	uid  int
+	gid  int
+	// Confirm asks the user to trust the unknown server public key
+	Confirm func(host string, cert *x509.Certificate, pin string) bool
+	mu      sync.Mutex
+}
+
+// Parse validates the SPKI SHA-256 pin and returns it in the canonical
+// "sha256//base64" form. The "sha256//" prefix is optional.
+func Parse(s string) (string, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(s), prefix)
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %q pin: %s", s, err)
+	}
+	if len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid %q pin length: expected %d bytes SHA-256 hash, got %d", s, sha256.Size, len(b))
+	}
+	return prefix + v, nil
+}
+
+// Hash returns the certificate SPKI SHA-256 pin
+func Hash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return prefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// New returns a new public key verifier. It returns nil, when there are no pins
+// and trust on first use mode is disabled. Trust on first use is ignored, when
+// the pins are set.
+func New(pins []string, tofu bool, path string, uid, gid int) (*Verifier, error) {
+	if len(pins) == 0 && !tofu {
+		return nil, nil
+	}
+	if len(pins) > 0 && tofu {
+		log.Printf("Ignoring trust on first use, the server is verified by the pins")
+		tofu = false
+	}
+
+	v := &Verifier{
+		pins: make(map[string]bool, len(pins)),
+		tofu: tofu,
+		path: path,
+		uid:  uid,
+		gid:  gid,
+	}
+	for _, p := range pins {
+		p, err := Parse(p)
+		if err != nil {
+			return nil, err
+		}
+		v.pins[p] = true
+	}
+
+	return v, nil
+}
+
+// VerifyConnection is a tls.Config.VerifyConnection function
+func (v *Verifier) VerifyConnection(cs tls.ConnectionState) error {
+	return v.Verify(cs.ServerName, cs.PeerCertificates)
+}
+
+// VerifyPeerCertificate returns a VerifyPeerCertificate function for the host,
+// it is used by DTLS, which doesn't expose the server name
+func (v *Verifier) VerifyPeerCertificate(host string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s certificate: %s", host, err)
+			}
+			certs[i] = cert
+		}
+		return v.Verify(host, certs)
+	}
+}
+
+// Verify verifies the server certificate chain, which is already verified by
+// the CA. The chain is trusted, when the server public key matches a pin, or
+// when a CA public key matches a pin and the server certificate is signed by
+// this CA. A pin mismatch is fatal. In trust on first use mode the server
+// public key must match the known servers record.
+func (v *Verifier) Verify(host string, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("%s server didn't provide a certificate", host)
+	}
+
+	if !v.tofu {
+		return v.verifyPins(host, certs)
+	}
+
+	leaf := certs[0]
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	known, err := v.readKnownServers()
+	if err != nil {
+		return err
+	}
+
+	pin := Hash(leaf)
+	if p, ok := known[host]; ok {
+		if p != pin {
+			return fmt.Errorf("%s server public key has changed: expected %s, got %s. If the change is expected, remove the %s record from %q", host, p, pin, host, v.path)
+		}
+		return nil
+	}
+
+	if v.Confirm == nil || !v.Confirm(host, leaf, pin) {
+		return fmt.Errorf("%s server public key %s is not trusted", host, pin)
+	}
+
+	return v.addKnownServer(host, pin)
+}
+
+func (v *Verifier) verifyPins(host string, certs []*x509.Certificate) error {
+	if len(v.pins) == 0 {
+		return fmt.Errorf("no pins defined")
+	}
+
+	if v.pins[Hash(certs[0])] {
+		return nil
+	}
+
+	// the pinned CA must sign the server certificate
+	for i, cert := range certs[1:] {
+		if !v.pins[Hash(cert)] {
+			continue
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1 : i+1] {
+			intermediates.AddCert(c)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			DNSName:       host,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to verify %s certificate using the pinned CA: %s", host, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s server public key %s doesn't match the pins", host, Hash(certs[0]))
+}
+
+// readKnownServers reads the "host pin" records
+func (v *Verifier) readKnownServers() (map[string]string, error) {
+	known := make(map[string]string)
+
+	raw, err := ioutil.ReadFile(v.path)
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known servers: %s", err)
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			log.Printf("Skipping invalid %q line %d", v.path, n)
+			continue
+		}
+		pin, err := Parse(fields[1])
+		if err != nil {
+			log.Printf("Skipping invalid %q line %d: %s", v.path, n, err)
+			continue
+		}
+		known[fields[0]] = pin
+	}
+
+	return known, nil
+}
+
+func (v *Verifier) addKnownServer(host, pin string) error {
+	f, err := os.OpenFile(v.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known servers: %s", err)
+	}
+	defer f.Close()
+
+	if _, err = fmt.Fprintf(f, "%s %s\n", host, pin); err != nil {
+		return fmt.Errorf("failed to save known server: %s", err)
+	}
+
+	// windows preserves the original user parameters, no need to chown
+	if runtime.GOOS != "windows" {
+		if err = f.Chown(v.uid, v.gid); err != nil {
+			return fmt.Errorf("failed to set an owner for known servers file: %s", err)
+		}
+	}
+
+	log.Printf("Added %s server public key to %q", host, v.path)
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package pin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if !isCA {
+		tmpl.DNSNames = []string{cn}
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestVerifyPins(t *testing.T) {
+	ca, caKey := newCert(t, "CA", true, nil, nil)
+	leaf, _ := newCert(t, "vpn.example.com", false, ca, caKey)
+	other, _ := newCert(t, "vpn.example.com", false, nil, nil)
+	chain := []*x509.Certificate{leaf, ca}
+
+	for _, v := range []struct {
+		name  string
+		pin   string
+		host  string
+		certs []*x509.Certificate
+		ok    bool
+	}{
+		{"server pin", Hash(leaf), "vpn.example.com", chain, true},
+		{"CA pin", Hash(ca), "vpn.example.com", chain, true},
+		{"CA pin, wrong host", Hash(ca), "other.example.com", chain, false},
+		{"CA pin, self-signed server", Hash(ca), "vpn.example.com", []*x509.Certificate{other, ca}, false},
+		{"wrong pin", Hash(other), "vpn.example.com", chain, false},
+	} {
+		verifier, err := New([]string{v.pin}, false, "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = verifier.Verify(v.host, v.certs); (err == nil) != v.ok {
+			t.Errorf("%s: unexpected result: %v", v.name, err)
+		}
+	}
+}
+
+func TestVerifyTOFU(t *testing.T) {
+	leaf, _ := newCert(t, "vpn.example.com", false, nil, nil)
+	other, _ := newCert(t, "vpn.example.com", false, nil, nil)
+
+	path := filepath.Join(t.TempDir(), KnownServersName)
+	verifier, err := New(nil, true, path, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var confirmed bool
+	verifier.Confirm = func(string, *x509.Certificate, string) bool {
+		return confirmed
+	}
+
+	if err = verifier.Verify("vpn.example.com", []*x509.Certificate{leaf}); err == nil {
+		t.Fatalf("expected the untrusted server to be rejected")
+	}
+
+	confirmed = true
+	if err = verifier.Verify("vpn.example.com", []*x509.Certificate{leaf}); err != nil {
+		t.Fatal(err)
+	}
+
+	confirmed = false
+	if err = verifier.Verify("vpn.example.com", []*x509.Certificate{leaf}); err != nil {
+		t.Fatalf("expected the known server to be trusted: %v", err)
+	}
+	if err = verifier.Verify("vpn.example.com", []*x509.Certificate{other}); err == nil {
+		t.Fatalf("expected the changed server key to be rejected")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, v := range []struct {
+		pin string
+		ok  bool
+	}{
+		{"sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"sha256//AAAA", false},
+		{"not base64", false},
+	} {
+		if _, err := Parse(v.pin); (err == nil) != v.ok {
+			t.Errorf("%q: unexpected result: %v", v.pin, err)
+		}
+	}
+}
+
+func TestVerifyPinsWithTOFU(t *testing.T) {
+	leaf, _ := newCert(t, "vpn.example.com", false, nil, nil)
+	other, _ := newCert(t, "vpn.example.com", false, nil, nil)
+
+	verifier, err := New([]string{Hash(leaf)}, true, filepath.Join(t.TempDir(), KnownServersName), -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.Confirm = func(string, *x509.Certificate, string) bool {
+		return true
+	}
+
+	if err = verifier.Verify("vpn.example.com", []*x509.Certificate{leaf}); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify("vpn.example.com", []*x509.Certificate{other}); err == nil {
+		t.Fatalf("expected the pin mismatch to be fatal")
+	}
+}
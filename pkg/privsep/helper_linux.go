@@ -0,0 +1,290 @@
+//go:build linux
+// +build linux
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/IBM/netaddr"
+	"github.com/kayrus/tuncfg/resolv"
+	"github.com/kayrus/tuncfg/route"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const cloneDevicePath = "/dev/net/tun"
+
+// iface is the interface, created by the helper
+type iface struct {
+	profile *Profile
+	// routes, added by the helper
+	routes *netaddr.IPSet
+	resolv *resolv.Handler
+}
+
+type helper struct {
+	policy *Policy
+	ifaces map[string]*iface
+}
+
+// Serve handles the unprivileged process requests, until the process closes
+// the connection. The leftover routes and DNS settings are restored on exit.
+func Serve(conn *net.UnixConn, policy *Policy) error {
+	// this is used only in linux/freebsd to store /etc/resolv.conf backup
+	resolv.AppName = "gof5"
+
+	h := &helper{
+		policy: policy,
+		ifaces: make(map[string]*iface),
+	}
+	defer h.closeAll()
+
+	for {
+		req := &request{}
+		fd, err := readMsg(conn, req)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fd >= 0 {
+			// the unprivileged process is not allowed to pass descriptors
+			unix.Close(fd)
+			return fmt.Errorf("unexpected file descriptor in %s request", req.Op)
+		}
+
+		resp, fd := h.handle(req)
+		if resp.Error != "" {
+			log.Printf("Refused %s request: %s", req.Op, resp.Error)
+		}
+		err = writeMsg(conn, resp, fd)
+		if fd >= 0 {
+			// the interface is held by the unprivileged process only
+			unix.Close(fd)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to send %s response: %s", req.Op, err)
+		}
+	}
+}
+
+func (h *helper) handle(req *request) (*response, int) {
+	if req.Op == opOpen {
+		name, fd, err := h.open(req)
+		if err != nil {
+			return &response{Error: err.Error()}, -1
+		}
+		return &response{Interface: name}, fd
+	}
+
+	i, ok := h.ifaces[req.Interface]
+	if !ok {
+		return &response{Error: fmt.Sprintf("%q interface is not created by the helper", req.Interface)}, -1
+	}
+
+	var err error
+	resp := &response{}
+	switch req.Op {
+	case opAddRoutes:
+		err = h.addRoutes(req.Interface, i, req.Routes)
+	case opDelRoutes:
+		err = h.delRoutes(req.Interface, i, req.Routes)
+	case opSetDNS:
+		resp.OriginalDNS, err = h.setDNS(req.Interface, i, req.DNS)
+	case opRestoreDNS:
+		h.restoreDNS(i)
+	case opClose:
+		h.close(req.Interface)
+	default:
+		err = fmt.Errorf("unknown %q operation", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	return resp, -1
+}
+
+func (h *helper) open(req *request) (string, int, error) {
+	if err := h.policy.checkOpen(req, len(h.ifaces)); err != nil {
+		return "", -1, err
+	}
+
+	name, fd, err := openTun(req.Interface, req.MTU, req.Local, req.Gateway)
+	if err != nil {
+		return "", -1, err
+	}
+
+	h.ifaces[name] = &iface{
+		profile: req.Profile,
+		routes:  &netaddr.IPSet{},
+	}
+	log.Printf("Created %s interface", name)
+
+	return name, fd, nil
+}
+
+func (h *helper) addRoutes(name string, i *iface, cidrs []string) error {
+	routes, err := parseNets(cidrs)
+	if err != nil {
+		return err
+	}
+	if err := h.policy.checkRoutes(i.profile, routes); err != nil {
+		return err
+	}
+
+	handler, err := route.New(name, routes, nil, 0)
+	if err != nil {
+		return err
+	}
+	handler.Add()
+	for _, v := range routes {
+		i.routes.InsertNet(v)
+	}
+
+	return nil
+}
+
+func (h *helper) delRoutes(name string, i *iface, cidrs []string) error {
+	routes, err := parseNets(cidrs)
+	if err != nil {
+		return err
+	}
+	for _, v := range routes {
+		if !i.routes.ContainsNet(v) {
+			return fmt.Errorf("%s route is not added by the helper", v)
+		}
+	}
+
+	handler, err := route.New(name, routes, nil, 0)
+	if err != nil {
+		return err
+	}
+	handler.Del()
+	for _, v := range routes {
+		i.routes.RemoveNet(v)
+	}
+
+	return nil
+}
+
+func (h *helper) setDNS(name string, i *iface, dns *DNS) ([]net.IP, error) {
+	if dns == nil {
+		return nil, fmt.Errorf("DNS configuration is required")
+	}
+	if i.resolv != nil {
+		return nil, fmt.Errorf("DNS is already configured")
+	}
+	if err := h.policy.checkDNS(i.profile, dns); err != nil {
+		return nil, err
+	}
+
+	handler, err := resolv.New(name, dns.Servers, dns.Suffixes, h.policy.RewriteResolv)
+	if err != nil {
+		return nil, err
+	}
+
+	if handler.IsResolve() {
+		handler.SetDNSServers(dns.Servers)
+		if len(dns.Domains) > 0 {
+			handler.SetDNSDomains(dns.Domains)
+		} else {
+			handler.SetDNSDomains([]string{"."})
+		}
+	} else if len(dns.Domains) > 0 {
+		return nil, fmt.Errorf("DNS zones require systemd-resolved, when privilege separation is used")
+	}
+
+	if err := handler.Set(); err != nil {
+		return nil, err
+	}
+	i.resolv = handler
+
+	return handler.GetOriginalDNS(), nil
+}
+
+func (h *helper) restoreDNS(i *iface) {
+	if i.resolv == nil {
+		return
+	}
+	log.Printf("Restoring DNS settings")
+	i.resolv.Restore()
+	i.resolv = nil
+}
+
+// close restores the interface routes and DNS settings
+func (h *helper) close(name string) {
+	i := h.ifaces[name]
+	if routes := i.routes.GetNetworks(); len(routes) > 0 {
+		log.Printf("Removing routes from %s interface", name)
+		if handler, err := route.New(name, routes, nil, 0); err == nil {
+			handler.Del()
+		}
+	}
+	h.restoreDNS(i)
+	delete(h.ifaces, name)
+}
+
+func (h *helper) closeAll() {
+	for name := range h.ifaces {
+		h.close(name)
+	}
+}
+
+// openTun creates the TUN interface without the packet information header,
+// sets its addresses and returns its file descriptor
+func openTun(name string, mtu int, local, gw net.IP) (string, int, error) {
+	fd, err := unix.Open(cloneDevicePath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to open %s: %s", cloneDevicePath, err)
+	}
+
+	name, err = setTun(fd, name, mtu, local, gw)
+	if err != nil {
+		unix.Close(fd)
+		return "", -1, fmt.Errorf("failed to create an interface: %s", err)
+	}
+
+	return name, fd, nil
+}
+
+func setTun(fd int, name string, mtu int, local, gw net.IP) (string, error) {
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return "", err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		return "", err
+	}
+	name = ifr.Name()
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return "", fmt.Errorf("failed to set %s interface MTU: %s", name, err)
+	}
+
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{IP: local, Mask: net.CIDRMask(32, 32)},
+		Peer:  &net.IPNet{IP: gw, Mask: net.CIDRMask(32, 32)},
+	}
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		return "", fmt.Errorf("failed to set peer address on %s interface: %s", name, err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return "", fmt.Errorf("failed to set %s interface up: %s", name, err)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,200 @@
+package privsep
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/util"
+
+	"github.com/IBM/netaddr"
+)
+
+const (
+	minMTU = 576
+	maxMTU = 1500
+)
+
+// Policy is the local configuration, which limits the helper requests. The
+// server profile is sent by the unprivileged process and is not trusted,
+// therefore the profile routes, DNS servers and suffixes must be a part of the
+// allowed lists from the config, which deny everything when empty. The profile
+// limits the changes made after the interface is created.
+type Policy struct {
+	// allowed interface names, the kernel picks a name, when it is empty
+	TunNames []string
+	// custom routes from the config
+	Routes []*net.IPNet
+	// networks, which may be a part of the server profile
+	AllowedRoutes []*net.IPNet
+	// DNS servers, which may be a part of the server profile
+	AllowedDNS []*net.IPNet
+	// DNS suffixes and their subdomains, which may be a part of the server
+	// profile
+	AllowedSuffixes []string
+	// DNS zones from the config, which are resolved through the VPN
+	Zones []string
+	// maximum amount of simultaneously created interfaces
+	MaxInterfaces int
+	// rewrite /etc/resolv.conf instead of renaming
+	RewriteResolv bool
+}
+
+// checkOpen verifies the interface creation request
+func (p *Policy) checkOpen(req *request, open int) error {
+	if open >= p.MaxInterfaces {
+		return fmt.Errorf("the limit of %d interfaces is reached", p.MaxInterfaces)
+	}
+	if req.Interface != "" && !util.StrSliceContains(p.TunNames, req.Interface) {
+		return fmt.Errorf("%q interface name is not allowed", req.Interface)
+	}
+	if req.MTU < minMTU || req.MTU > maxMTU {
+		return fmt.Errorf("MTU %d is out of the %d-%d range", req.MTU, minMTU, maxMTU)
+	}
+	for _, v := range []net.IP{req.Local, req.Gateway} {
+		if !validUnicast(v) {
+			return fmt.Errorf("invalid %q interface address", v)
+		}
+	}
+	if req.Profile == nil {
+		return fmt.Errorf("server profile is required")
+	}
+	return p.checkProfile(req.Profile)
+}
+
+// checkProfile verifies that the server profile is a part of the allowed
+// lists from the config, the values are written into the system resolver
+// configuration
+func (p *Policy) checkProfile(profile *Profile) error {
+	nets, err := parseNets(profile.Routes)
+	if err != nil {
+		return err
+	}
+	allowed := &netaddr.IPSet{}
+	for _, v := range p.AllowedRoutes {
+		allowed.InsertNet(v)
+	}
+	for _, v := range nets {
+		if !allowed.ContainsNet(v) {
+			return fmt.Errorf("%s profile route is not allowed by the config", v)
+		}
+	}
+
+	allowed = &netaddr.IPSet{}
+	for _, v := range p.AllowedDNS {
+		allowed.InsertNet(v)
+	}
+	for _, v := range profile.DNS {
+		if !validUnicast(v) {
+			return fmt.Errorf("invalid %q DNS server", v)
+		}
+		if !allowed.Contains(v.To4()) {
+			return fmt.Errorf("%q profile DNS server is not allowed by the config", v)
+		}
+	}
+
+	for _, v := range profile.Suffixes {
+		if !validDomain(v) {
+			return fmt.Errorf("invalid %q DNS suffix", v)
+		}
+		if !inDomains(p.AllowedSuffixes, v) {
+			return fmt.Errorf("%q profile DNS suffix is not allowed by the config", v)
+		}
+	}
+	return nil
+}
+
+// checkRoutes verifies that every route is a part of the server profile or
+// the config routes
+func (p *Policy) checkRoutes(profile *Profile, routes []*net.IPNet) error {
+	allowed := &netaddr.IPSet{}
+	for _, v := range p.Routes {
+		allowed.InsertNet(v)
+	}
+	nets, err := parseNets(profile.Routes)
+	if err != nil {
+		return err
+	}
+	for _, v := range nets {
+		allowed.InsertNet(v)
+	}
+
+	for _, v := range routes {
+		if !allowed.ContainsNet(v) {
+			return fmt.Errorf("%s route is not allowed by the server profile", v)
+		}
+	}
+	return nil
+}
+
+// checkDNS verifies that the DNS servers and suffixes are a part of the server
+// profile and the zones are a part of the config
+func (p *Policy) checkDNS(profile *Profile, dns *DNS) error {
+	for _, v := range dns.Servers {
+		if !containsIP(profile.DNS, v) {
+			return fmt.Errorf("%q DNS server is not allowed by the server profile", v)
+		}
+	}
+	for _, v := range dns.Suffixes {
+		if !util.StrSliceContains(profile.Suffixes, v) {
+			return fmt.Errorf("%q DNS suffix is not allowed by the server profile", v)
+		}
+	}
+	for _, v := range dns.Domains {
+		if !util.StrSliceContains(p.Zones, v) {
+			return fmt.Errorf("%q DNS zone is not allowed by the config", v)
+		}
+	}
+	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inDomains reports whether the name is one of the domains or their
+// subdomain
+func inDomains(domains []string, name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, v := range domains {
+		v = strings.ToLower(strings.TrimSuffix(v, "."))
+		if name == v || strings.HasSuffix(name, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+func validUnicast(ip net.IP) bool {
+	return ip.To4() != nil && ip.IsGlobalUnicast()
+}
+
+// validDomain verifies that the domain name consists of the hostname
+// characters only
+func validDomain(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z',
+				c >= 'A' && c <= 'Z',
+				c >= '0' && c <= '9',
+				c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package privsep
+
+import (
+	"net"
+	"testing"
+)
+
+func parseNet(s string) *net.IPNet {
+	_, n, _ := net.ParseCIDR(s)
+	return n
+}
+
+func TestCheckRoutes(t *testing.T) {
+	policy := &Policy{
+		Routes: []*net.IPNet{parseNet("192.168.100.0/24")},
+	}
+	profile := &Profile{
+		Routes: []string{"10.0.0.0/8", "172.16.0.0/16"},
+	}
+
+	for _, v := range []struct {
+		route string
+		valid bool
+	}{
+		{"10.0.0.0/8", true},
+		{"10.20.0.0/16", true},
+		{"172.16.1.1/32", true},
+		{"192.168.100.128/25", true},
+		{"0.0.0.0/0", false},
+		{"172.0.0.0/8", false},
+		{"192.168.0.0/16", false},
+		{"8.8.8.8/32", false},
+	} {
+		err := policy.checkRoutes(profile, []*net.IPNet{parseNet(v.route)})
+		if (err == nil) != v.valid {
+			t.Errorf("%s: expected valid %t, got %v", v.route, v.valid, err)
+		}
+	}
+}
+
+func TestCheckDNS(t *testing.T) {
+	policy := &Policy{
+		Zones: []string{".corp.example.com."},
+	}
+	profile := &Profile{
+		DNS:      []net.IP{net.ParseIP("10.0.0.53")},
+		Suffixes: []string{"corp.example.com"},
+	}
+
+	for i, v := range []struct {
+		dns   DNS
+		valid bool
+	}{
+		{DNS{Servers: []net.IP{net.ParseIP("10.0.0.53")}, Suffixes: []string{"corp.example.com"}}, true},
+		{DNS{Servers: []net.IP{net.ParseIP("10.0.0.53")}, Domains: []string{".corp.example.com."}}, true},
+		{DNS{Servers: []net.IP{net.ParseIP("1.1.1.1")}}, false},
+		{DNS{Suffixes: []string{"example.com"}}, false},
+		{DNS{Domains: []string{"."}}, false},
+	} {
+		err := policy.checkDNS(profile, &v.dns)
+		if (err == nil) != v.valid {
+			t.Errorf("%d: expected valid %t, got %v", i, v.valid, err)
+		}
+	}
+}
+
+func TestCheckOpen(t *testing.T) {
+	policy := &Policy{
+		TunNames:        []string{"corp0"},
+		AllowedRoutes:   []*net.IPNet{parseNet("10.0.0.0/8")},
+		AllowedDNS:      []*net.IPNet{parseNet("10.0.0.0/24")},
+		AllowedSuffixes: []string{"example.com"},
+		MaxInterfaces:   1,
+	}
+	valid := func() *request {
+		return &request{
+			Op:      opOpen,
+			MTU:     1332,
+			Local:   net.ParseIP("10.1.1.2"),
+			Gateway: net.ParseIP("10.1.1.1"),
+			Profile: &Profile{
+				Routes:   []string{"10.0.0.0/8"},
+				DNS:      []net.IP{net.ParseIP("10.0.0.53")},
+				Suffixes: []string{"corp.example.com"},
+			},
+		}
+	}
+
+	if err := policy.checkOpen(valid(), 0); err != nil {
+		t.Errorf("expected valid request, got %v", err)
+	}
+	if err := policy.checkOpen(valid(), 1); err == nil {
+		t.Errorf("expected the interfaces limit error")
+	}
+	// the profile is sent by the unprivileged process, empty allowed lists
+	// deny it
+	if err := (&Policy{MaxInterfaces: 1}).checkOpen(valid(), 0); err == nil {
+		t.Errorf("expected the empty allowed lists to deny the profile")
+	}
+
+	for name, modify := range map[string]func(*request){
+		"interface name": func(r *request) { r.Interface = "eth0" },
+		"MTU":            func(r *request) { r.MTU = 9000 },
+		"local address":  func(r *request) { r.Local = net.ParseIP("127.0.0.1") },
+		"gateway":        func(r *request) { r.Gateway = nil },
+		"profile":        func(r *request) { r.Profile = nil },
+		"route":          func(r *request) { r.Profile.Routes = []string{"2001:db8::/32"} },
+		"allowed route":  func(r *request) { r.Profile.Routes = []string{"0.0.0.0/0"} },
+		"DNS server":     func(r *request) { r.Profile.DNS = []net.IP{net.ParseIP("224.0.0.1")} },
+		"DNS suffix":     func(r *request) { r.Profile.Suffixes = []string{"example.com\nnameserver 1.1.1.1"} },
+		"allowed DNS":    func(r *request) { r.Profile.DNS = []net.IP{net.ParseIP("1.1.1.1")} },
+		"allowed suffix": func(r *request) { r.Profile.Suffixes = []string{"badexample.com"} },
+	} {
+		req := valid()
+		modify(req)
+		if err := policy.checkOpen(req, 0); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package privsep implements the privilege separation: a minimal privileged
+// helper creates the TUN interface, sets routes and DNS, and an unprivileged
+// process does the authentication and the data path. The processes talk over
+// a socketpair, each message is a single JSON encoded datagram, the TUN file
+// descriptor is passed using SCM_RIGHTS.
+package privsep
+
+import (
+	"fmt"
+	"net"
+)
+
+// EnvFD is the environment variable, which contains the unprivileged process
+// socket file descriptor
+const EnvFD = "GOF5_PRIVSEP_FD"
+
+// maxMessageSize is the maximum message size, larger messages are rejected
+const maxMessageSize = 64 << 10
+
+// helper operations
+const (
+	opOpen       = "open"
+	opAddRoutes  = "addRoutes"
+	opDelRoutes  = "delRoutes"
+	opSetDNS     = "setDNS"
+	opRestoreDNS = "restoreDNS"
+	opClose      = "close"
+)
+
+// Profile is the network profile, pushed by the server. It is pinned, when
+// the interface is created, and cannot be changed afterwards.
+type Profile struct {
+	Routes   []string `json:"routes,omitempty"`
+	DNS      []net.IP `json:"dns,omitempty"`
+	Suffixes []string `json:"suffixes,omitempty"`
+}
+
+// DNS is the interface DNS configuration
+type DNS struct {
+	Servers  []net.IP `json:"servers,omitempty"`
+	Suffixes []string `json:"suffixes,omitempty"`
+	// DNS zones, resolved by the interface DNS servers, systemd-resolved only
+	Domains []string `json:"domains,omitempty"`
+}
+
+// request is sent by the unprivileged process to the helper
+type request struct {
+	Op        string   `json:"op"`
+	Interface string   `json:"interface,omitempty"`
+	MTU       int      `json:"mtu,omitempty"`
+	Local     net.IP   `json:"local,omitempty"`
+	Gateway   net.IP   `json:"gateway,omitempty"`
+	Profile   *Profile `json:"profile,omitempty"`
+	Routes    []string `json:"routes,omitempty"`
+	DNS       *DNS     `json:"dns,omitempty"`
+}
+
+// response is sent by the helper to the unprivileged process
+type response struct {
+	Error       string   `json:"error,omitempty"`
+	Interface   string   `json:"interface,omitempty"`
+	OriginalDNS []net.IP `json:"originalDNS,omitempty"`
+}
+
+// NewProfile converts the server routes into the profile
+func NewProfile(routes []*net.IPNet, dns []net.IP, suffixes []string) *Profile {
+	return &Profile{
+		Routes:   formatNets(routes),
+		DNS:      dns,
+		Suffixes: suffixes,
+	}
+}
+
+func formatNets(nets []*net.IPNet) []string {
+	res := make([]string, 0, len(nets))
+	for _, v := range nets {
+		res = append(res, v.String())
+	}
+	return res
+}
+
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(cidrs))
+	for _, v := range cidrs {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q route: %s", v, err)
+		}
+		if n.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid %q route: only IPv4 routes are supported", v)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
@@ -0,0 +1,238 @@
+//go:build linux
+// +build linux
+
+package privsep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// Client sends the network configuration requests to the privileged helper
+type Client struct {
+	sync.Mutex
+	conn *net.UnixConn
+}
+
+// FromEnv returns the helper client, when the process was started by the
+// helper, and nil otherwise
+func FromEnv() (*Client, error) {
+	v := os.Getenv(EnvFD)
+	if v == "" {
+		return nil, nil
+	}
+	// hooks must not inherit the variable
+	os.Unsetenv(EnvFD)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s file descriptor: %s", EnvFD, err)
+	}
+
+	conn, err := fileConn(os.NewFile(uintptr(fd), "privsep"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+// Pair returns the connected helper socket and the unprivileged process socket
+// file, which must be passed to the process
+func Pair() (*net.UnixConn, *os.File, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create a socketpair: %s", err)
+	}
+
+	conn, err := fileConn(os.NewFile(uintptr(fds[0]), "privsep"))
+	if err != nil {
+		unix.Close(fds[1])
+		return nil, nil, err
+	}
+
+	return conn, os.NewFile(uintptr(fds[1]), "privsep"), nil
+}
+
+// fileConn converts the socket file into a connection and closes the file
+func fileConn(f *os.File) (*net.UnixConn, error) {
+	defer f.Close()
+
+	c, err := net.FileConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open privsep socket: %s", err)
+	}
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("privsep socket is not a unix socket")
+	}
+
+	return conn, nil
+}
+
+// writeMsg sends the message and the optional file descriptor
+func writeMsg(conn *net.UnixConn, v interface{}, fd int) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessageSize {
+		return fmt.Errorf("message exceeds the %d bytes limit", maxMessageSize)
+	}
+
+	var oob []byte
+	if fd >= 0 {
+		oob = unix.UnixRights(fd)
+	}
+
+	_, _, err = conn.WriteMsgUnix(b, oob, nil)
+	return err
+}
+
+// readMsg receives the message and the optional file descriptor, io.EOF is
+// returned, when the peer is closed
+func readMsg(conn *net.UnixConn, v interface{}) (int, error) {
+	b := make([]byte, maxMessageSize)
+	oob := make([]byte, unix.CmsgSpace(4))
+
+	n, oobn, flags, _, err := conn.ReadMsgUnix(b, oob)
+	if err != nil {
+		return -1, err
+	}
+
+	fd := -1
+	if oobn > 0 {
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return -1, fmt.Errorf("failed to parse control message: %s", err)
+		}
+		for _, msg := range msgs {
+			fds, err := unix.ParseUnixRights(&msg)
+			if err != nil {
+				continue
+			}
+			for _, v := range fds {
+				if fd < 0 {
+					fd = v
+				} else {
+					unix.Close(v)
+				}
+			}
+		}
+	}
+
+	if flags&(unix.MSG_TRUNC|unix.MSG_CTRUNC) != 0 {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return -1, fmt.Errorf("truncated message")
+	}
+	if n == 0 {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return -1, io.EOF
+	}
+
+	if err := json.Unmarshal(b[:n], v); err != nil {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return -1, fmt.Errorf("failed to decode message: %s", err)
+	}
+
+	return fd, nil
+}
+
+// call sends the request and waits for the response
+func (c *Client) call(req *request) (*response, int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := writeMsg(c.conn, req, -1); err != nil {
+		return nil, -1, fmt.Errorf("failed to send %s request to the helper: %s", req.Op, err)
+	}
+
+	resp := &response{}
+	fd, err := readMsg(c.conn, resp)
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to receive %s response from the helper: %s", req.Op, err)
+	}
+	if resp.Error != "" {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return nil, -1, fmt.Errorf("helper refused %s request: %s", req.Op, resp.Error)
+	}
+
+	return resp, fd, nil
+}
+
+// OpenTun creates the TUN interface and pins the server profile to it
+func (c *Client) OpenTun(name string, mtu int, local, gw net.IP, profile *Profile) (*tun.NativeTun, error) {
+	req := &request{
+		Op:        opOpen,
+		Interface: name,
+		MTU:       mtu,
+		Local:     local,
+		Gateway:   gw,
+		Profile:   profile,
+	}
+	_, fd, err := c.call(req)
+	if err != nil {
+		return nil, err
+	}
+	if fd < 0 {
+		return nil, fmt.Errorf("helper didn't pass the interface file descriptor")
+	}
+
+	dev, _, err := tun.CreateUnmonitoredTUNFromFD(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to open the interface: %s", err)
+	}
+
+	return dev.(*tun.NativeTun), nil
+}
+
+// AddRoutes adds the routes to the interface
+func (c *Client) AddRoutes(name string, routes []*net.IPNet) error {
+	_, _, err := c.call(&request{Op: opAddRoutes, Interface: name, Routes: formatNets(routes)})
+	return err
+}
+
+// DelRoutes removes the routes from the interface
+func (c *Client) DelRoutes(name string, routes []*net.IPNet) error {
+	_, _, err := c.call(&request{Op: opDelRoutes, Interface: name, Routes: formatNets(routes)})
+	return err
+}
+
+// SetDNS configures the system resolver and returns the original DNS servers
+func (c *Client) SetDNS(name string, dns *DNS) ([]net.IP, error) {
+	resp, _, err := c.call(&request{Op: opSetDNS, Interface: name, DNS: dns})
+	if err != nil {
+		return nil, err
+	}
+	return resp.OriginalDNS, nil
+}
+
+// RestoreDNS restores the system resolver configuration
+func (c *Client) RestoreDNS(name string) error {
+	_, _, err := c.call(&request{Op: opRestoreDNS, Interface: name})
+	return err
+}
+
+// Close removes the leftover interface routes and DNS configuration
+func (c *Client) Close(name string) error {
+	_, _, err := c.call(&request{Op: opClose, Interface: name})
+	return err
+}
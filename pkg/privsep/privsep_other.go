@@ -0,0 +1,45 @@
+//go:build !linux
+// +build !linux
+
+package privsep
+
+import (
+	"fmt"
+	"net"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+var errNotSupported = fmt.Errorf("privilege separation is supported only in Linux")
+
+// Client sends the network configuration requests to the privileged helper
+type Client struct{}
+
+// FromEnv returns nil, privilege separation is supported only in Linux
+func FromEnv() (*Client, error) {
+	return nil, nil
+}
+
+func (c *Client) OpenTun(name string, mtu int, local, gw net.IP, profile *Profile) (*tun.NativeTun, error) {
+	return nil, errNotSupported
+}
+
+func (c *Client) AddRoutes(name string, routes []*net.IPNet) error {
+	return errNotSupported
+}
+
+func (c *Client) DelRoutes(name string, routes []*net.IPNet) error {
+	return errNotSupported
+}
+
+func (c *Client) SetDNS(name string, dns *DNS) ([]net.IP, error) {
+	return nil, errNotSupported
+}
+
+func (c *Client) RestoreDNS(name string) error {
+	return errNotSupported
+}
+
+func (c *Client) Close(name string) error {
+	return errNotSupported
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// supported proxy schemes
+var schemes = []string{"http", "https", "socks5", "socks5h"}
+
+var defaultPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
+}
+
+// Parse parses a proxy URL, the scheme defaults to http
+func Parse(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q proxy URL: %s", s, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", u.Redacted())
+	}
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return nil, fmt.Errorf("unsupported %q proxy scheme, supported schemes are: %q", u.Scheme, schemes)
+	}
+	return u, nil
+}
+
+// URL returns the proxy URL, which should be used to connect to the server.
+// When the proxy is not defined, the HTTPS_PROXY and NO_PROXY environment
+// variables are used.
+func URL(s string, server string) (*url.URL, error) {
+	if s != "" {
+		return Parse(s)
+	}
+
+	u, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: server}})
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return Parse(u.String())
+}
+
+// Addr returns the proxy host:port
+func Addr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPorts[u.Scheme]
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// LookupIP resolves the proxy IP addresses
+func LookupIP(u *url.URL) ([]net.IP, error) {
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return net.LookupIP(u.Hostname())
+}
+
+// Dialer returns a dialer, which connects through the proxy using the
+// forward dialer
+func Dialer(u *url.URL, forward *net.Dialer) (proxy.Dialer, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return &httpDialer{url: u, forward: forward}, nil
+	}
+	return proxy.FromURL(u, forward)
+}
+
+// httpDialer establishes connections using the HTTP CONNECT method
+type httpDialer struct {
+	url     *url.URL
+	forward *net.Dialer
+}
+
+func (d *httpDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *httpDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, "tcp", Addr(d.url))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s proxy: %s", d.url.Redacted(), err)
+	}
+
+	// abort the CONNECT request
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if d.url.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.url.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to establish TLS connection with %s proxy: %s", d.url.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.url.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT request to %s proxy: %s", d.url.Redacted(), err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from %s proxy: %s", d.url.Redacted(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("%s proxy refused to connect to %s: %s", d.url.Redacted(), addr, resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		// the server has already sent data
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn reads the data buffered while reading the CONNECT response
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
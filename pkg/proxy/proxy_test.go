@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, v := range []struct {
+		url    string
+		scheme string
+		addr   string
+		valid  bool
+	}{
+		{"proxy.corp:3128", "http", "proxy.corp:3128", true},
+		{"https://proxy.corp", "https", "proxy.corp:443", true},
+		{"socks5h://user:pass@[2001:db8::1]", "socks5h", "[2001:db8::1]:1080", true},
+		{"ftp://proxy.corp", "", "", false},
+		{"http://", "", "", false},
+	} {
+		u, err := Parse(v.url)
+		if (err == nil) != v.valid {
+			t.Errorf("%q: expected valid %t, got %v", v.url, v.valid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if u.Scheme != v.scheme || Addr(u) != v.addr {
+			t.Errorf("%q: expected %s %s, got %s %s", v.url, v.scheme, v.addr, u.Scheme, Addr(u))
+		}
+	}
+}
+
+// serve accepts a single connection and runs the proxy handshake
+func serve(t *testing.T, handshake func(conn net.Conn) error) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := handshake(conn); err != nil {
+			t.Errorf("proxy handshake failed: %s", err)
+		}
+	}()
+
+	return &url.URL{Host: l.Addr().String()}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	u := serve(t, func(conn net.Conn) error {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return err
+		}
+		if req.Method != http.MethodConnect || req.Host != "vpn.example.com:443" {
+			_, err = io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return err
+		}
+		if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			_, err = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return err
+		}
+		// the data right after the response must not be lost
+		_, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\nhello")
+		return err
+	})
+	u.Scheme = "http"
+	u.User = url.UserPassword("user", "pass")
+
+	d, err := Dialer(u, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", "vpn.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("expected hello, got %q", buf)
+	}
+}
+
+func TestHTTPConnectRefused(t *testing.T) {
+	u := serve(t, func(conn net.Conn) error {
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return err
+		}
+		_, err := io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\n\r\n")
+		return err
+	})
+	u.Scheme = "http"
+
+	d, err := Dialer(u, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Dial("tcp", "vpn.example.com:443"); err == nil {
+		t.Errorf("expected the CONNECT request to be refused")
+	}
+}
+
+func TestSOCKS5(t *testing.T) {
+	u := serve(t, func(conn net.Conn) error {
+		// greeting: version, methods
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		methods := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte{5, 0}); err != nil {
+			return err
+		}
+
+		// request: version, connect, reserved, domain name type
+		req := make([]byte, 5)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return err
+		}
+		if req[1] != 1 || req[3] != 3 {
+			_, err := conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+			return err
+		}
+		host := make([]byte, int(req[4])+2)
+		if _, err := io.ReadFull(conn, host); err != nil {
+			return err
+		}
+		if string(host[:req[4]]) != "vpn.example.com" || binary.BigEndian.Uint16(host[req[4]:]) != 443 {
+			_, err := conn.Write([]byte{5, 2, 0, 1, 0, 0, 0, 0, 0, 0})
+			return err
+		}
+		if _, err := conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0}); err != nil {
+			return err
+		}
+		_, err := io.WriteString(conn, "hello")
+		return err
+	})
+	u.Scheme = "socks5h"
+
+	d, err := Dialer(u, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", "vpn.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("expected hello, got %q", buf)
+	}
+}
@@ -0,0 +1,123 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"kernel.org/pub/linux/libs/security/libcap/psx"
+)
+
+var errLandlockUnsupported = errors.New("landlock is not supported")
+
+// rights, which can be applied to a file, not only to a directory
+const fileRights = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+	unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+// rights returns the Landlock rights of the access level
+func rights(a Access) uint64 {
+	v := uint64(unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR)
+	if a >= AccessWrite {
+		v |= unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+			unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+			unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	if a >= AccessReplace {
+		v |= unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+			unix.LANDLOCK_ACCESS_FS_MAKE_SYM |
+			unix.LANDLOCK_ACCESS_FS_REMOVE_FILE
+	}
+	if a >= AccessFull {
+		v |= unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+			unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+			unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+			unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	return v
+}
+
+// handledRights returns the rights, known to the Landlock ABI version, the
+// rest of the rights cannot be restricted
+func handledRights(abi int) uint64 {
+	// ABI 1: from EXECUTE to MAKE_SYM
+	v := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		v |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		v |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		v |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return v
+}
+
+func landlock(rules []Rule) error {
+	abi, _, e := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if e != 0 || abi < 1 {
+		return errLandlockUnsupported
+	}
+	handled := handledRights(int(abi))
+
+	attr := unix.LandlockRulesetAttr{
+		Access_fs: handled,
+	}
+	fd, _, e := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if e != 0 {
+		return fmt.Errorf("failed to create Landlock ruleset: %s", e)
+	}
+	defer unix.Close(int(fd))
+
+	for _, rule := range rules {
+		if err := addRule(int(fd), rule, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, e := psx.Syscall3(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); e != 0 {
+		return fmt.Errorf("failed to apply Landlock ruleset: %s", e)
+	}
+
+	return nil
+}
+
+func addRule(ruleset int, rule Rule, handled uint64) error {
+	fd, err := unix.Open(rule.Path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		// nothing to allow
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %s", rule.Path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("failed to stat %q: %s", rule.Path, err)
+	}
+
+	access := rights(rule.Access) & handled
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= fileRights
+	}
+
+	attr := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(fd),
+	}
+	if _, _, e := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); e != 0 {
+		return fmt.Errorf("failed to add %q Landlock rule: %s", rule.Path, e)
+	}
+
+	return nil
+}
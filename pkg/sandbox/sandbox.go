@@ -0,0 +1,27 @@
+// Package sandbox restricts the process, when the tunnel is established: a
+// seccomp-bpf filter allows only the syscalls, required by the data path and
+// the configuration restore, and a Landlock ruleset limits the filesystem
+// access to the listed paths.
+package sandbox
+
+// Access is the filesystem access level
+type Access int
+
+const (
+	// AccessRead allows reading files and listing directories
+	AccessRead Access = iota
+	// AccessWrite additionally allows writing and truncating files
+	AccessWrite
+	// AccessReplace additionally allows creating and removing files and
+	// symlinks, e.g. to rename /etc/resolv.conf
+	AccessReplace
+	// AccessFull allows everything, except executing files and creating
+	// special files
+	AccessFull
+)
+
+// Rule allows the filesystem access beneath the path
+type Rule struct {
+	Path   string
+	Access Access
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"kernel.org/pub/linux/libs/security/libcap/psx"
+)
+
+var applied struct {
+	sync.Mutex
+	done bool
+}
+
+// Apply restricts all the process threads, the restrictions cannot be lifted.
+// Subsequent calls, e.g. on reconnect, are no-op.
+func Apply(rules []Rule) error {
+	applied.Lock()
+	defer applied.Unlock()
+
+	if applied.done {
+		return nil
+	}
+
+	// required by the unprivileged Landlock and seccomp, it is per thread
+	if _, _, e := psx.Syscall6(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0); e != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %s", e)
+	}
+
+	err := landlock(rules)
+	if errors.Is(err, errLandlockUnsupported) {
+		log.Printf("Landlock is not supported by the kernel, the filesystem access is not restricted")
+	} else if err != nil {
+		return err
+	}
+
+	if err := seccomp(); err != nil {
+		return err
+	}
+	applied.done = true
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import (
+	"fmt"
+)
+
+// Apply is supported only in Linux
+func Apply(_ []Rule) error {
+	return fmt.Errorf("sandbox is supported only in Linux")
+}
@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// struct seccomp_data offsets
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	// the lower half of the first syscall argument on the little endian
+	// architectures
+	offsetArg0 = 16
+)
+
+// cloneNewFlags are the clone flags, which create new namespaces
+const cloneNewFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET |
+	unix.CLONE_NEWTIME
+
+func seccomp() error {
+	if auditArch == 0 {
+		return fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	filter := seccompFilter(auditArch, allowedSyscalls)
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	// TSYNC applies the filter to all the process threads
+	r, _, e := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if e != 0 {
+		return fmt.Errorf("failed to apply seccomp filter: %s", e)
+	}
+	if r != 0 {
+		return fmt.Errorf("failed to apply seccomp filter: cannot synchronize %d thread", r)
+	}
+
+	return nil
+}
+
+// seccompFilter returns the BPF program, which kills the process on a foreign
+// architecture syscall, allows the listed syscalls and fails the rest with
+// EPERM. clone is allowed only without the namespace flags. clone3 fails with
+// ENOSYS, its flags are passed in memory, which seccomp cannot inspect, the Go
+// runtime creates threads using clone.
+func seccompFilter(arch uint32, syscalls []uintptr) []unix.SockFilter {
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE3, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE, 0, 4),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArg0),
+		jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, cloneNewFlags, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+	}
+	// a pair per syscall keeps the jump offsets within the 8 bit limit
+	for _, nr := range syscalls {
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+		)
+	}
+	return append(filter, stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)))
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
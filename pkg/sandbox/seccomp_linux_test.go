@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// run evaluates the filter, which uses only absolute loads, equality and bit
+// test jumps and returns
+func run(filter []unix.SockFilter, arch, nr, arg0 uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		f := filter[pc]
+		switch f.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			switch f.K {
+			case offsetArch:
+				acc = arch
+			case offsetArg0:
+				acc = arg0
+			default:
+				acc = nr
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == f.K {
+				pc += int(f.Jt)
+			} else {
+				pc += int(f.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K:
+			if acc&f.K != 0 {
+				pc += int(f.Jt)
+			} else {
+				pc += int(f.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return f.K
+		}
+	}
+	return 0
+}
+
+func TestSeccompFilter(t *testing.T) {
+	const arch = unix.AUDIT_ARCH_X86_64
+	filter := seccompFilter(arch, []uintptr{0, 1, 300})
+
+	// the Go runtime thread flags
+	const thread = unix.CLONE_VM | unix.CLONE_FS | unix.CLONE_FILES | unix.CLONE_SIGHAND |
+		unix.CLONE_SYSVSEM | unix.CLONE_THREAD
+
+	for _, v := range []struct {
+		arch     uint32
+		nr       uint32
+		arg0     uint32
+		expected uint32
+	}{
+		{arch, 0, 0, unix.SECCOMP_RET_ALLOW},
+		{arch, 300, 0, unix.SECCOMP_RET_ALLOW},
+		{arch, 59, 0, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{unix.AUDIT_ARCH_I386, 0, 0, unix.SECCOMP_RET_KILL_PROCESS},
+		{arch, unix.SYS_CLONE, thread, unix.SECCOMP_RET_ALLOW},
+		{arch, unix.SYS_CLONE, unix.CLONE_NEWUSER | unix.CLONE_NEWNET, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{arch, unix.SYS_CLONE, thread | unix.CLONE_NEWNS, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{arch, unix.SYS_CLONE3, 0, unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)},
+	} {
+		if res := run(filter, v.arch, v.nr, v.arg0); res != v.expected {
+			t.Errorf("%x/%d/%x: expected %x, got %x", v.arch, v.nr, v.arg0, v.expected, res)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package sandbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// allowedSyscalls are required by the Go runtime, the TLS, DTLS, TUN and DNS
+// proxy I/O, the reconnect and the routes and DNS restore. Process execution,
+// namespaces, mounts, credentials, ptrace, BPF and kernel modules are denied.
+// clone and clone3 are checked separately by the seccomp filter.
+var allowedSyscalls = append([]uintptr{
+	// Go runtime
+	unix.SYS_BRK,
+	unix.SYS_CLOCK_GETRES,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+	unix.SYS_FUTEX,
+	unix.SYS_GETPID,
+	unix.SYS_GETPPID,
+	unix.SYS_GETRANDOM,
+	unix.SYS_GETTID,
+	unix.SYS_GETTIMEOFDAY,
+	unix.SYS_KILL,
+	unix.SYS_MADVISE,
+	unix.SYS_MMAP,
+	unix.SYS_MPROTECT,
+	unix.SYS_MREMAP,
+	unix.SYS_MUNMAP,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_PRLIMIT64,
+	unix.SYS_RESTART_SYSCALL,
+	unix.SYS_RSEQ,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_SET_TID_ADDRESS,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_TGKILL,
+	unix.SYS_TIMER_CREATE,
+	unix.SYS_TIMER_DELETE,
+	unix.SYS_TIMER_SETTIME,
+	unix.SYS_UNAME,
+	unix.SYS_GETUID,
+	unix.SYS_GETEUID,
+	unix.SYS_GETGID,
+	unix.SYS_GETEGID,
+	// file descriptors and polling
+	unix.SYS_CLOSE,
+	unix.SYS_DUP,
+	unix.SYS_DUP3,
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EVENTFD2,
+	unix.SYS_FCNTL,
+	unix.SYS_IOCTL,
+	unix.SYS_PIPE2,
+	unix.SYS_PPOLL,
+	unix.SYS_PSELECT6,
+	unix.SYS_READ,
+	unix.SYS_READV,
+	unix.SYS_PREAD64,
+	unix.SYS_WRITE,
+	unix.SYS_WRITEV,
+	unix.SYS_PWRITE64,
+	unix.SYS_LSEEK,
+	// files, cookies, captures and the resolver configuration
+	unix.SYS_FACCESSAT,
+	unix.SYS_FACCESSAT2,
+	unix.SYS_FCHMOD,
+	unix.SYS_FCHMODAT,
+	unix.SYS_FCHOWN,
+	unix.SYS_FCHOWNAT,
+	unix.SYS_FDATASYNC,
+	unix.SYS_FLOCK,
+	unix.SYS_FSTAT,
+	unix.SYS_FSTATFS,
+	unix.SYS_FSYNC,
+	unix.SYS_FTRUNCATE,
+	unix.SYS_GETCWD,
+	unix.SYS_GETDENTS64,
+	unix.SYS_INOTIFY_ADD_WATCH,
+	unix.SYS_INOTIFY_INIT1,
+	unix.SYS_INOTIFY_RM_WATCH,
+	unix.SYS_MKDIRAT,
+	unix.SYS_OPENAT,
+	unix.SYS_READLINKAT,
+	unix.SYS_RENAMEAT,
+	unix.SYS_RENAMEAT2,
+	unix.SYS_STATFS,
+	unix.SYS_STATX,
+	unix.SYS_UMASK,
+	unix.SYS_UNLINKAT,
+	unix.SYS_UTIMENSAT,
+	// sockets, including netlink and D-Bus
+	unix.SYS_ACCEPT,
+	unix.SYS_ACCEPT4,
+	unix.SYS_BIND,
+	unix.SYS_CONNECT,
+	unix.SYS_GETPEERNAME,
+	unix.SYS_GETSOCKNAME,
+	unix.SYS_GETSOCKOPT,
+	unix.SYS_LISTEN,
+	unix.SYS_RECVFROM,
+	unix.SYS_RECVMMSG,
+	unix.SYS_RECVMSG,
+	unix.SYS_SENDMMSG,
+	unix.SYS_SENDMSG,
+	unix.SYS_SENDTO,
+	unix.SYS_SETSOCKOPT,
+	unix.SYS_SHUTDOWN,
+	unix.SYS_SOCKET,
+	unix.SYS_SOCKETPAIR,
+}, archSyscalls...)
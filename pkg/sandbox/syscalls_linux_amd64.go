@@ -0,0 +1,32 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+package sandbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// legacy syscalls, which are not available in the generic syscall table
+var archSyscalls = []uintptr{
+	unix.SYS_ACCESS,
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_DUP2,
+	unix.SYS_EPOLL_CREATE,
+	unix.SYS_EPOLL_WAIT,
+	unix.SYS_GETRLIMIT,
+	unix.SYS_LSTAT,
+	unix.SYS_MKDIR,
+	unix.SYS_NEWFSTATAT,
+	unix.SYS_OPEN,
+	unix.SYS_PIPE,
+	unix.SYS_POLL,
+	unix.SYS_READLINK,
+	unix.SYS_RENAME,
+	unix.SYS_RMDIR,
+	unix.SYS_SELECT,
+	unix.SYS_STAT,
+	unix.SYS_UNLINK,
+}
@@ -0,0 +1,14 @@
+//go:build linux && arm64
+// +build linux,arm64
+
+package sandbox
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+var archSyscalls = []uintptr{
+	unix.SYS_FSTATAT,
+}
@@ -0,0 +1,9 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package sandbox
+
+// seccomp filter is not implemented for the architecture
+const auditArch = 0
+
+var allowedSyscalls []uintptr
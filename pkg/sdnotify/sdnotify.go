@@ -0,0 +1,69 @@
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// notification states
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns the free-form service status notification
+func Status(format string, args ...interface{}) string {
+	return "STATUS=" + fmt.Sprintf(format, args...)
+}
+
+// Notify sends the state notifications to the service manager. It returns
+// false, when the NOTIFY_SOCKET is not set, i.e. the process is not started
+// by systemd with the notify service type.
+func Notify(states ...string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// abstract socket
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to the notify socket: %s", err)
+	}
+	defer conn.Close()
+
+	var msg []byte
+	for _, v := range states {
+		msg = append(msg, v...)
+		msg = append(msg, '\n')
+	}
+	if _, err = conn.Write(msg); err != nil {
+		return false, fmt.Errorf("failed to send the notification: %s", err)
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval returns the service watchdog timeout, 0 when the watchdog
+// is disabled
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if v := os.Getenv("WATCHDOG_PID"); v != "" {
+		pid, err := strconv.Atoi(v)
+		if err != nil || pid != os.Getpid() {
+			// the watchdog is set for another process
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,60 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if ok, err := Notify(Ready); ok || err != nil {
+		t.Fatalf("expected no notification without NOTIFY_SOCKET, got %t, %v", ok, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets are not supported: %s", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if ok, err := Notify(Ready, Status("Connected to %s", "vpn.example.com")); !ok || err != nil {
+		t.Fatalf("expected a notification, got %t, %v", ok, err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, expected := string(buf[:n]), "READY=1\nSTATUS=Connected to vpn.example.com\n"; v != expected {
+		t.Errorf("expected %q, got %q", expected, v)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, v := range []struct {
+		usec     string
+		pid      string
+		expected time.Duration
+	}{
+		{"", "", 0},
+		{"invalid", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", pid, 30 * time.Second},
+		{"30000000", "1", 0},
+	} {
+		t.Setenv("WATCHDOG_USEC", v.usec)
+		t.Setenv("WATCHDOG_PID", v.pid)
+		if d := WatchdogInterval(); d != v.expected {
+			t.Errorf("%q/%q: expected %s, got %s", v.usec, v.pid, v.expected, d)
+		}
+	}
+}